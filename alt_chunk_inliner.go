@@ -0,0 +1,392 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+type noteKind struct {
+	relType    string
+	element    string
+	references []string
+}
+
+var noteKinds = []noteKind{
+	{relTypeFootnotes, "footnote", []string{"footnoteReference"}},
+	{relTypeEndnotes, "endnote", []string{"endnoteReference"}},
+	{relTypeComments, "comment", []string{"commentReference", "commentRangeStart", "commentRangeEnd"}},
+}
+
+type altChunkInliner struct {
+	pkg          *Package
+	partName     string
+	content      []byte
+	document     *etree.Document
+	snapshot     *xmlSnapshot
+	rels         *Relationships
+	contentTypes *ContentTypes
+	bookmarks    *bookmarkAllocator
+	notes        map[string]*notesPart
+}
+
+type altChunkSource struct {
+	pkg          *Package
+	partName     string
+	rels         *Relationships
+	contentTypes *ContentTypes
+	notes        map[string]*notesPart
+}
+
+type notesPart struct {
+	partName string
+	document *etree.Document
+	rels     *Relationships
+	byID     map[string]*etree.Element
+	nextID   int
+}
+
+func newAltChunkInliner(pkg *Package, partName string, rels *Relationships) (*altChunkInliner, error) {
+	content, err := pkg.ReadPart(partName)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения %s: %w", partName, err)
+	}
+	document := etree.NewDocument()
+	if err := document.ReadFromBytes(content); err != nil {
+		return nil, fmt.Errorf("ошибка чтения %s: %w", partName, err)
+	}
+	contentTypes, err := readContentTypes(pkg)
+	if err != nil {
+		return nil, err
+	}
+	return &altChunkInliner{
+		pkg:          pkg,
+		partName:     partName,
+		content:      content,
+		document:     document,
+		snapshot:     takeXMLSnapshot(document),
+		rels:         rels,
+		contentTypes: contentTypes,
+		bookmarks:    newBookmarkAllocator(document.Root()),
+		notes:        make(map[string]*notesPart),
+	}, nil
+}
+
+func (ai *altChunkInliner) inline(relID string, chunkPkg *Package) (bool, error) {
+	var altChunks []*etree.Element
+	for _, altChunk := range findAllElements(ai.document.Root(), "//w:altChunk") {
+		if id, ok := getRelationshipAttribute(altChunk, "id"); ok && id == relID {
+			altChunks = append(altChunks, altChunk)
+		}
+	}
+	if len(altChunks) == 0 {
+		return false, nil
+	}
+
+	chunkParts, err := locatePackageParts(chunkPkg)
+	if err != nil {
+		return false, err
+	}
+	chunkDocumentContent, err := chunkPkg.ReadPart(chunkParts.MainDocument)
+	if err != nil {
+		return false, fmt.Errorf("ошибка чтения документа altChunk: %w", err)
+	}
+	chunkDocument := etree.NewDocument()
+	if err := chunkDocument.ReadFromBytes(chunkDocumentContent); err != nil {
+		return false, fmt.Errorf("ошибка чтения документа altChunk: %w", err)
+	}
+	chunkRoot := chunkDocument.Root()
+	body := findElement(chunkRoot, "./w:body")
+	if body == nil {
+		return false, nil
+	}
+
+	chunk := &altChunkSource{pkg: chunkPkg, partName: chunkParts.MainDocument, notes: make(map[string]*notesPart)}
+	if chunk.rels, err = readPartRelationships(chunkPkg, chunkParts.MainDocument); err != nil {
+		return false, err
+	}
+	if chunk.contentTypes, err = readContentTypes(chunkPkg); err != nil {
+		return false, err
+	}
+
+	var content []*etree.Element
+	for _, child := range body.ChildElements() {
+		if isWordElement(child, "sectPr") {
+			continue
+		}
+		content = append(content, child)
+	}
+
+	copiedIDs := make(map[string]string)
+	for _, element := range content {
+		if err := remapChunkRelationships(element, chunkPkg, ai.pkg, chunkParts.MainDocument, ai.partName, chunk.rels, chunk.contentTypes, ai.rels, ai.contentTypes, copiedIDs); err != nil {
+			return false, err
+		}
+	}
+
+	for _, altChunk := range altChunks {
+		parent := altChunk.Parent()
+		var inlined []*etree.Element
+		for _, element := range content {
+			inlined = append(inlined, copyWithNamespaces(element, chunkRoot, parent))
+		}
+		ai.snapshot.replaceElement(altChunk, inlined)
+		ai.remapBookmarks(inlined)
+		if err := ai.copyNotes(chunk, inlined); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (ai *altChunkInliner) remapBookmarks(elements []*etree.Element) {
+	ids := make(map[string]string)
+	renamed := make(map[string]string)
+	for _, bookmarkStart := range wordElementsWithin(elements, "bookmarkStart") {
+		if id, ok := getAttribute(bookmarkStart, "id"); ok {
+			ids[id] = strconv.Itoa(ai.bookmarks.nextID)
+			ai.bookmarks.nextID++
+			setAttribute(bookmarkStart, "id", ids[id])
+		}
+		if name, ok := getAttribute(bookmarkStart, "name"); ok {
+			if unique := ai.bookmarks.uniqueName(name); unique != name {
+				renamed[name] = unique
+				setAttribute(bookmarkStart, "name", unique)
+			}
+		}
+	}
+	for _, bookmarkEnd := range wordElementsWithin(elements, "bookmarkEnd") {
+		if id, ok := getAttribute(bookmarkEnd, "id"); ok && ids[id] != "" {
+			setAttribute(bookmarkEnd, "id", ids[id])
+		}
+	}
+	if len(renamed) == 0 {
+		return
+	}
+
+	for _, hyperlink := range wordElementsWithin(elements, "hyperlink") {
+		if anchor, ok := getAttribute(hyperlink, "anchor"); ok && renamed[anchor] != "" {
+			setAttribute(hyperlink, "anchor", renamed[anchor])
+		}
+	}
+	for _, fldSimple := range wordElementsWithin(elements, "fldSimple") {
+		if instruction, ok := getAttribute(fldSimple, "instr"); ok {
+			setAttribute(fldSimple, "instr", renameFieldBookmark(instruction, renamed))
+		}
+	}
+	for _, instrText := range wordElementsWithin(elements, "instrText") {
+		instrText.SetText(renameFieldBookmark(instrText.Text(), renamed))
+	}
+}
+
+func renameFieldBookmark(instruction string, renamed map[string]string) string {
+	field := ParseFieldInstruction(instruction)
+	if (field.Name != "REF" && field.Name != "PAGEREF" && field.Name != "NOTEREF") || len(field.Arguments) == 0 {
+		return instruction
+	}
+	name, ok := renamed[field.Arguments[0]]
+	if !ok {
+		return instruction
+	}
+	offset := strings.Index(strings.ToUpper(instruction), field.Name) + len(field.Name)
+	return instruction[:offset] + strings.Replace(instruction[offset:], field.Arguments[0], name, 1)
+}
+
+func (ai *altChunkInliner) copyNotes(chunk *altChunkSource, elements []*etree.Element) error {
+	for _, kind := range noteKinds {
+		var references []*etree.Element
+		for _, reference := range kind.references {
+			references = append(references, wordElementsWithin(elements, reference)...)
+		}
+		if len(references) == 0 {
+			continue
+		}
+		source, err := chunk.readNotes(kind)
+		if err != nil {
+			return err
+		} else if source == nil {
+			continue
+		}
+		target, err := ai.hostNotes(kind, chunk, source)
+		if err != nil {
+			return err
+		}
+
+		ids := make(map[string]string)
+		copiedIDs := make(map[string]string)
+		for _, reference := range references {
+			id, ok := getAttribute(reference, "id")
+			if !ok {
+				continue
+			}
+			newID, copied := ids[id]
+			if !copied {
+				note := source.byID[id]
+				if note == nil {
+					continue
+				}
+				newID = strconv.Itoa(target.nextID)
+				target.nextID++
+				ids[id] = newID
+
+				targetRoot := target.document.Root()
+				copiedNote := copyWithNamespaces(note, source.document.Root(), targetRoot)
+				targetRoot.AddChild(copiedNote)
+				setAttribute(copiedNote, "id", newID)
+				if err := remapChunkRelationships(copiedNote, chunk.pkg, ai.pkg, source.partName, target.partName, source.rels, chunk.contentTypes, target.rels, ai.contentTypes, copiedIDs); err != nil {
+					return err
+				}
+				ai.remapBookmarks([]*etree.Element{copiedNote})
+			}
+			setAttribute(reference, "id", newID)
+		}
+	}
+	return nil
+}
+
+func (cs *altChunkSource) readNotes(kind noteKind) (*notesPart, error) {
+	if notes, ok := cs.notes[kind.relType]; ok {
+		return notes, nil
+	}
+	partName := relatedPartName(cs.partName, cs.rels, kind.relType)
+	content, err := readOptionalPart(cs.pkg, partName)
+	if err != nil || content == nil {
+		cs.notes[kind.relType] = nil
+		return nil, err
+	}
+	notes, err := parseNotesPart(cs.pkg, partName, content, kind)
+	if err != nil {
+		return nil, err
+	}
+	cs.notes[kind.relType] = notes
+	return notes, nil
+}
+
+func (ai *altChunkInliner) hostNotes(kind noteKind, chunk *altChunkSource, source *notesPart) (*notesPart, error) {
+	if notes := ai.notes[kind.relType]; notes != nil {
+		return notes, nil
+	}
+
+	partName := relatedPartName(ai.partName, ai.rels, kind.relType)
+	content, err := readOptionalPart(ai.pkg, partName)
+	if err != nil {
+		return nil, err
+	}
+	if content == nil {
+		if partName == "" {
+			partName = path.Join(path.Dir(ai.partName), path.Base(source.partName))
+			if ai.pkg.HasPart(partName) {
+				partName = uniqueChunkPartName(ai.pkg, partName)
+			}
+			ai.rels.Add(kind.relType, relativeRelationshipTarget(ai.partName, partName), "")
+		}
+		if contentType := chunk.contentTypes.ContentType(source.partName); contentType != "" {
+			ai.contentTypes.SetOverride(partName, contentType)
+		}
+
+		document := etree.NewDocument()
+		document.CreateProcInst("xml", `version="1.0" encoding="UTF-8" standalone="yes"`)
+		root := source.document.Root().Copy()
+		for _, note := range root.ChildElements() {
+			if _, special := getAttribute(note, "type"); !special {
+				root.RemoveChild(note)
+			}
+		}
+		document.SetRoot(root)
+		if content, err = document.WriteToBytes(); err != nil {
+			return nil, err
+		}
+	}
+
+	notes, err := parseNotesPart(ai.pkg, partName, content, kind)
+	if err != nil {
+		return nil, err
+	}
+	ai.notes[kind.relType] = notes
+	return notes, nil
+}
+
+func parseNotesPart(pkg *Package, partName string, content []byte, kind noteKind) (*notesPart, error) {
+	document := etree.NewDocument()
+	if err := document.ReadFromBytes(content); err != nil {
+		return nil, fmt.Errorf("ошибка чтения %s: %w", partName, err)
+	}
+	rels, err := readPartRelationships(pkg, partName)
+	if err != nil {
+		return nil, err
+	}
+	notes := &notesPart{partName: partName, document: document, rels: rels, byID: make(map[string]*etree.Element)}
+	for _, note := range findAllElements(document.Root(), "./w:"+kind.element) {
+		id, ok := getAttribute(note, "id")
+		if !ok {
+			continue
+		}
+		notes.byID[id] = note
+		if n, err := strconv.Atoi(id); err == nil && n >= notes.nextID {
+			notes.nextID = n + 1
+		}
+	}
+	return notes, nil
+}
+
+func (ai *altChunkInliner) finish() error {
+	content, err := ai.snapshot.serialize(ai.document, ai.content)
+	if err != nil {
+		return fmt.Errorf("ошибка записи %s: %w", ai.partName, err)
+	}
+	ai.pkg.WritePart(ai.partName, content)
+
+	for _, kind := range noteKinds {
+		notes := ai.notes[kind.relType]
+		if notes == nil {
+			continue
+		}
+		output, err := notes.document.WriteToBytes()
+		if err != nil {
+			return fmt.Errorf("ошибка записи %s: %w", notes.partName, err)
+		}
+		ai.pkg.WritePart(notes.partName, output)
+		if len(notes.rels.All()) > 0 {
+			if err := writePartRelationships(ai.pkg, notes.partName, notes.rels); err != nil {
+				return fmt.Errorf("ошибка записи связей %s: %w", notes.partName, err)
+			}
+		}
+	}
+
+	if err := writePartRelationships(ai.pkg, ai.partName, ai.rels); err != nil {
+		return fmt.Errorf("ошибка записи связей документа: %w", err)
+	}
+	return writeContentTypes(ai.pkg, ai.contentTypes)
+}
+
+func copyWithNamespaces(element, sourceRoot, parent *etree.Element) *etree.Element {
+	copied := element.Copy()
+	for _, attr := range sourceRoot.Attr {
+		isDeclaration := attr.Space == "xmlns" || (attr.Space == "" && attr.Key == "xmlns")
+		if !isDeclaration || copied.SelectAttr(attr.FullKey()) != nil {
+			continue
+		}
+		prefix := attr.Key
+		if attr.Space == "" {
+			prefix = ""
+		}
+		if lookupNamespaceURI(parent, prefix) != attr.Value {
+			copied.CreateAttr(attr.FullKey(), attr.Value)
+		}
+	}
+	return copied
+}
+
+func wordElementsWithin(elements []*etree.Element, tagNameLocal string) []*etree.Element {
+	var result []*etree.Element
+	for _, element := range elements {
+		if isWordElement(element, tagNameLocal) {
+			result = append(result, element)
+		}
+		result = append(result, findAllElements(element, ".//w:"+tagNameLocal)...)
+	}
+	return result
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+const (
+	BackupNone      = "none"
+	BackupBak       = "bak"
+	BackupTimestamp = "timestamp"
+
+	backupExt             = ".bak"
+	backupTimestampLayout = "20060102-150405"
+	outputFileMode        = 0644
+)
+
+var (
+	ErrOutputExists     = errors.New("файл результата уже существует")
+	ErrDecryptedInPlace = errors.New("обработка на месте заменила бы зашифрованный файл расшифрованным; включите повторное шифрование или сохраните результат в другой файл")
+)
+
+func isSameFile(pathA, pathB string) bool {
+	infoA, err := os.Stat(pathA)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(pathB)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
+
+func writeFileAtomic(target string, noClobber bool, write func(io.Writer) error, beforeCommit func() error) error {
+	mode := os.FileMode(outputFileMode)
+	if info, err := os.Stat(target); err == nil {
+		if noClobber {
+			return fmt.Errorf("%w: %s", ErrOutputExists, target)
+		}
+		mode = info.Mode().Perm()
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("ошибка создания временного файла: %w", err)
+	}
+	tempPath := temp.Name()
+	committed := false
+	defer func() {
+		if !committed {
+			temp.Close()
+			os.Remove(tempPath)
+		}
+	}()
+
+	if err := write(temp); err != nil {
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		return fmt.Errorf("ошибка записи результата: %w", err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("ошибка записи результата: %w", err)
+	}
+	if err := os.Chmod(tempPath, mode); err != nil {
+		return fmt.Errorf("ошибка записи результата: %w", err)
+	}
+	if beforeCommit != nil {
+		if err := beforeCommit(); err != nil {
+			return err
+		}
+	}
+
+	if noClobber {
+		if err := os.Link(tempPath, target); err != nil {
+			if os.IsExist(err) {
+				return fmt.Errorf("%w: %s", ErrOutputExists, target)
+			}
+			return fmt.Errorf("ошибка сохранения результата: %w", err)
+		}
+		committed = true
+		os.Remove(tempPath)
+	} else {
+		if err := os.Rename(tempPath, target); err != nil {
+			return fmt.Errorf("ошибка сохранения результата: %w", err)
+		}
+		committed = true
+	}
+	if err := syncDir(filepath.Dir(target)); err != nil {
+		return fmt.Errorf("ошибка сохранения результата: %w", err)
+	}
+	return nil
+}
+
+func createBackup(path, mode string) (string, error) {
+	base := path + backupExt
+	if mode == BackupTimestamp {
+		base = path + "." + time.Now().Format(backupTimestampLayout) + backupExt
+	}
+	for n := 0; ; n++ {
+		backup := base
+		if n > 0 {
+			backup = base + "." + strconv.Itoa(n)
+		}
+		err := os.Link(path, backup)
+		if err != nil && !os.IsExist(err) {
+			err = copyFile(path, backup)
+		}
+		if os.IsExist(err) {
+			continue
+		} else if err != nil {
+			os.Remove(backup)
+			return "", fmt.Errorf("ошибка создания резервной копии: %w", err)
+		}
+		return backup, nil
+	}
+}
+
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func copyFile(source, target string) error {
+	input, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	info, err := input.Stat()
+	if err != nil {
+		return err
+	}
+	output, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(output, input); err != nil {
+		output.Close()
+		return err
+	}
+	if err := output.Sync(); err != nil {
+		output.Close()
+		return err
+	}
+	return output.Close()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateBackupKeepsExistingBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "document.docx")
+	for _, content := range []string{"первая", "вторая", "третья"} {
+		os.Remove(path)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := createBackup(path, BackupBak); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for backup, want := range map[string]string{
+		path + ".bak":   "первая",
+		path + ".bak.1": "вторая",
+		path + ".bak.2": "третья",
+	} {
+		if data, err := os.ReadFile(backup); err != nil || string(data) != want {
+			t.Errorf("резервная копия %s: %q, %v; ожидалось %q", filepath.Base(backup), data, err, want)
+		}
+	}
+}
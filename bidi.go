@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/beevik/etree"
+)
+
+const rightToLeftMark = "\u200f"
+
+func isOnOffEnabled(element *etree.Element) bool {
+	if element == nil {
+		return false
+	}
+	val, ok := getAttribute(element, "val")
+	return !ok || isOnOffValue(val)
+}
+
+func isOnOffValue(val string) bool {
+	switch val {
+	case "0", "false", "off":
+		return false
+	}
+	return true
+}
+
+func onOffAttributeValue(element *etree.Element) string {
+	if val, ok := getAttribute(element, "val"); ok && val != "" {
+		return val
+	}
+	return "1"
+}
+
+func isBidiParagraph(paragraph *etree.Element, styleSheet *StyleSheet) bool {
+	if bidi := findElement(paragraph, "./w:pPr/w:bidi"); bidi != nil {
+		return isOnOffEnabled(bidi)
+	}
+	styleID := ""
+	if pStyleElement := findElement(paragraph, "./w:pPr/w:pStyle"); pStyleElement != nil {
+		styleID, _ = getAttribute(pStyleElement, "val")
+	}
+	return styleSheet.StyleBidi(styleID)
+}
+
+func isNumberRightAligned(level *NumberingLevel) bool {
+	return level != nil && (level.Justification == "right" || level.Justification == "end")
+}
+
+func alignNumberRun(paragraph, numberRun *etree.Element, level *NumberingLevel) {
+	if level == nil || (!isNumberRightAligned(level) && level.Justification != "center") {
+		return
+	}
+	ind := findElement(paragraph, "./w:pPr/w:ind")
+	if ind == nil {
+		return
+	}
+
+	left := indentationValue(ind, "left", "start")
+	firstLineStart := left - indentationValue(ind, "hanging") + indentationValue(ind, "firstLine")
+	if firstLineStart <= 0 {
+		return
+	}
+
+	tabType := "center"
+	if isNumberRightAligned(level) {
+		tabType = "right"
+	}
+
+	pPr := ind.Parent()
+	tabs := findElement(pPr, "./w:tabs")
+	if tabs == nil {
+		tabs = createElement(pPr, "tabs", nil)
+		insertParagraphProperty(pPr, tabs)
+	}
+	tab := createElement(tabs, "tab", nil)
+	setAttribute(tab, "val", tabType)
+	setAttribute(tab, "pos", strconv.Itoa(firstLineStart))
+	tabs.AddChild(tab)
+
+	for _, attr := range []string{"firstLine", "firstLineChars", "hangingChars"} {
+		if existing := findAttr(ind, wordProcessingMLPrefix, attr); existing != nil {
+			ind.RemoveAttr(existing.FullKey())
+		}
+	}
+	setAttribute(ind, "hanging", strconv.Itoa(left))
+
+	index := 0
+	if rPr := findElement(numberRun, "./w:rPr"); rPr != nil {
+		index = rPr.Index() + 1
+	}
+	numberRun.InsertChildAt(index, createElement(numberRun, "tab", nil))
+}
+
+func indentationValue(ind *etree.Element, attrNames ...string) int {
+	for _, attrName := range attrNames {
+		if val, ok := getAttribute(ind, attrName); ok {
+			if n, err := strconv.Atoi(val); err == nil {
+				return n
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/beevik/etree"
+)
+
+const (
+	DefaultClauseBookmarkPrefix = "clause"
+	maxBookmarkNameLength       = 40
+	duplicateBookmarkSeparator  = "__"
+)
+
+type bookmarkAllocator struct {
+	names  map[string]bool
+	nextID int
+}
+
+func newBookmarkAllocator(root *etree.Element) *bookmarkAllocator {
+	allocator := &bookmarkAllocator{names: make(map[string]bool)}
+	for _, bookmarkStart := range findAllElements(root, "//w:bookmarkStart") {
+		if name, ok := getAttribute(bookmarkStart, "name"); ok {
+			allocator.names[name] = true
+		}
+		if id, ok := getAttribute(bookmarkStart, "id"); ok {
+			if n, err := strconv.Atoi(id); err == nil && n >= allocator.nextID {
+				allocator.nextID = n + 1
+			}
+		}
+	}
+	return allocator
+}
+
+func (ba *bookmarkAllocator) uniqueName(name string) string {
+	if len(name) > maxBookmarkNameLength {
+		name = name[:maxBookmarkNameLength]
+	}
+	candidate := name
+	for i := 2; ba.names[candidate]; i++ {
+		suffix := duplicateBookmarkSeparator + strconv.Itoa(i)
+		base := name
+		if len(base)+len(suffix) > maxBookmarkNameLength {
+			base = base[:maxBookmarkNameLength-len(suffix)]
+		}
+		candidate = base + suffix
+	}
+	ba.names[candidate] = true
+	return candidate
+}
+
+func (ba *bookmarkAllocator) wrapParagraph(paragraph *etree.Element, name string) {
+	id := strconv.Itoa(ba.nextID)
+	ba.nextID++
+
+	bookmarkStart := createElement(paragraph, "bookmarkStart", nil)
+	setAttribute(bookmarkStart, "id", id)
+	setAttribute(bookmarkStart, "name", name)
+	bookmarkEnd := createElement(paragraph, "bookmarkEnd", nil)
+	setAttribute(bookmarkEnd, "id", id)
+
+	index := 0
+	if pPr := findElement(paragraph, "./w:pPr"); pPr != nil {
+		index = pPr.Index() + 1
+	}
+	paragraph.InsertChildAt(index, bookmarkStart)
+	paragraph.AddChild(bookmarkEnd)
+}
+
+func addClauseBookmarks(root *etree.Element, paragraphNumbers map[*etree.Element]*ParagraphNumber, prefix string) {
+	if !IsValidBookmarkPrefix(prefix) {
+		prefix = DefaultClauseBookmarkPrefix
+	}
+	allocator := newBookmarkAllocator(root)
+
+	for _, paragraph := range findAllElements(root, "//w:p") {
+		number, ok := paragraphNumbers[paragraph]
+		if !ok {
+			continue
+		}
+		if name := clauseBookmarkName(prefix, number.FullContext()); name != "" {
+			allocator.wrapParagraph(paragraph, allocator.uniqueName(name))
+		}
+	}
+}
+
+func IsValidBookmarkPrefix(prefix string) bool {
+	if prefix == "" {
+		return false
+	}
+	for i, r := range prefix {
+		if r >= unicode.MaxASCII || !(unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r))) {
+			return false
+		}
+	}
+	return true
+}
+
+func clauseBookmarkName(prefix, number string) string {
+	parts := strings.FieldsFunc(number, func(r rune) bool {
+		return !(r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)))
+	})
+	if len(parts) == 0 {
+		return ""
+	}
+	return prefix + "_" + strings.Join(parts, "_")
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	compoundFileSignature   = "\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1"
+	compoundHeaderSize      = 512
+	compoundSectorSize      = 512
+	compoundSectorShift     = 9
+	compoundMiniSectorSize  = 64
+	compoundMiniSectorShift = 6
+	compoundMiniStreamLimit = 4096
+	compoundDirEntrySize    = 128
+	compoundHeaderDIFATSize = 109
+
+	compoundMaxRegularSector = 0xFFFFFFFA
+	compoundDIFATSector      = 0xFFFFFFFC
+	compoundFATSector        = 0xFFFFFFFD
+	compoundEndOfChain       = 0xFFFFFFFE
+	compoundFreeSector       = 0xFFFFFFFF
+	compoundNoStream         = 0xFFFFFFFF
+
+	compoundEntryEmpty   = 0
+	compoundEntryStorage = 1
+	compoundEntryStream  = 2
+	compoundEntryRoot    = 5
+)
+
+type CompoundEntry struct {
+	Name     string
+	Storage  bool
+	CLSID    [16]byte
+	Data     []byte
+	Children []*CompoundEntry
+}
+
+func (ce *CompoundEntry) Child(name string) *CompoundEntry {
+	for _, child := range ce.Children {
+		if strings.EqualFold(child.Name, name) {
+			return child
+		}
+	}
+	return nil
+}
+
+func (ce *CompoundEntry) Stream(path ...string) ([]byte, bool) {
+	entry := ce
+	for _, name := range path {
+		if entry = entry.Child(name); entry == nil {
+			return nil, false
+		}
+	}
+	if entry.Storage {
+		return nil, false
+	}
+	return entry.Data, true
+}
+
+type compoundDirEntry struct {
+	name      string
+	kind      byte
+	left      uint32
+	right     uint32
+	child     uint32
+	clsid     [16]byte
+	start     uint32
+	size      uint64
+	populated bool
+}
+
+type compoundReader struct {
+	data       []byte
+	sectorSize int
+	fat        []uint32
+	miniFAT    []uint32
+	miniStream []byte
+	cutoff     uint64
+}
+
+func isCompoundFile(header []byte) bool {
+	return bytes.HasPrefix(header, []byte(compoundFileSignature))
+}
+
+func ReadCompoundFile(data []byte) (*CompoundEntry, error) {
+	if len(data) < compoundHeaderSize || !isCompoundFile(data) {
+		return nil, fmt.Errorf("файл не является составным документом OLE")
+	}
+	sectorShift := binary.LittleEndian.Uint16(data[0x1E:])
+	if sectorShift != 9 && sectorShift != 12 {
+		return nil, fmt.Errorf("неподдерживаемый размер сектора составного документа: 2^%d", sectorShift)
+	}
+	r := &compoundReader{
+		data:       data,
+		sectorSize: 1 << sectorShift,
+		cutoff:     uint64(binary.LittleEndian.Uint32(data[0x38:])),
+	}
+
+	var fatSectors []uint32
+	for i := 0; i < compoundHeaderDIFATSize; i++ {
+		if sector := binary.LittleEndian.Uint32(data[0x4C+4*i:]); sector <= compoundMaxRegularSector {
+			fatSectors = append(fatSectors, sector)
+		}
+	}
+	perSector := r.sectorSize / 4
+	visited := make(map[uint32]bool)
+	for sector := binary.LittleEndian.Uint32(data[0x44:]); sector <= compoundMaxRegularSector && !visited[sector]; {
+		visited[sector] = true
+		content, err := r.sector(sector)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < perSector-1; i++ {
+			if fatSector := binary.LittleEndian.Uint32(content[4*i:]); fatSector <= compoundMaxRegularSector {
+				fatSectors = append(fatSectors, fatSector)
+			}
+		}
+		sector = binary.LittleEndian.Uint32(content[4*(perSector-1):])
+	}
+	for _, sector := range fatSectors {
+		content, err := r.sector(sector)
+		if err != nil {
+			return nil, err
+		}
+		r.fat = append(r.fat, readUint32s(content)...)
+	}
+
+	directory, err := r.readChain(binary.LittleEndian.Uint32(data[0x30:]), 0)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения каталога составного документа: %w", err)
+	}
+	var entries []compoundDirEntry
+	for offset := 0; offset+compoundDirEntrySize <= len(directory); offset += compoundDirEntrySize {
+		entries = append(entries, parseCompoundDirEntry(directory[offset:offset+compoundDirEntrySize], sectorShift == 9))
+	}
+	if len(entries) == 0 || entries[0].kind != compoundEntryRoot {
+		return nil, fmt.Errorf("в составном документе отсутствует корневой элемент")
+	}
+
+	miniFAT, err := r.readChain(binary.LittleEndian.Uint32(data[0x3C:]), 0)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения MiniFAT: %w", err)
+	}
+	r.miniFAT = readUint32s(miniFAT)
+	if r.miniStream, err = r.readChain(entries[0].start, entries[0].size); err != nil {
+		return nil, fmt.Errorf("ошибка чтения мини-потока: %w", err)
+	}
+
+	root := &CompoundEntry{Name: entries[0].name, Storage: true, CLSID: entries[0].clsid}
+	if err := r.readChildren(root, entries, entries[0].child, make(map[uint32]bool)); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+func parseCompoundDirEntry(raw []byte, version3 bool) compoundDirEntry {
+	entry := compoundDirEntry{
+		kind:  raw[66],
+		left:  binary.LittleEndian.Uint32(raw[68:]),
+		right: binary.LittleEndian.Uint32(raw[72:]),
+		child: binary.LittleEndian.Uint32(raw[76:]),
+		start: binary.LittleEndian.Uint32(raw[116:]),
+		size:  binary.LittleEndian.Uint64(raw[120:]),
+	}
+	copy(entry.clsid[:], raw[80:96])
+	if version3 {
+		entry.size &= 0xFFFFFFFF
+	}
+	nameLength := int(binary.LittleEndian.Uint16(raw[64:]))/2 - 1
+	if nameLength > 31 {
+		nameLength = 31
+	}
+	var units []uint16
+	for i := 0; i < nameLength; i++ {
+		units = append(units, binary.LittleEndian.Uint16(raw[2*i:]))
+	}
+	entry.name = string(utf16.Decode(units))
+	return entry
+}
+
+func (r *compoundReader) readChildren(parent *CompoundEntry, entries []compoundDirEntry, id uint32, visited map[uint32]bool) error {
+	if id == compoundNoStream {
+		return nil
+	}
+	if int(id) >= len(entries) || visited[id] {
+		return fmt.Errorf("поврежден каталог составного документа")
+	}
+	visited[id] = true
+	entry := entries[id]
+
+	if err := r.readChildren(parent, entries, entry.left, visited); err != nil {
+		return err
+	}
+	child := &CompoundEntry{Name: entry.name, CLSID: entry.clsid}
+	switch entry.kind {
+	case compoundEntryStorage:
+		child.Storage = true
+		if err := r.readChildren(child, entries, entry.child, visited); err != nil {
+			return err
+		}
+		parent.Children = append(parent.Children, child)
+	case compoundEntryStream:
+		data, err := r.streamData(entry)
+		if err != nil {
+			return fmt.Errorf("ошибка чтения потока %s: %w", entry.name, err)
+		}
+		child.Data = data
+		parent.Children = append(parent.Children, child)
+	}
+	return r.readChildren(parent, entries, entry.right, visited)
+}
+
+func (r *compoundReader) streamData(entry compoundDirEntry) ([]byte, error) {
+	if entry.size == 0 {
+		return []byte{}, nil
+	}
+	if entry.size >= r.cutoff {
+		return r.readChain(entry.start, entry.size)
+	}
+
+	var data []byte
+	visited := make(map[uint32]bool)
+	for sector := entry.start; uint64(len(data)) < entry.size; {
+		if sector > compoundMaxRegularSector || int(sector) >= len(r.miniFAT) || visited[sector] {
+			return nil, fmt.Errorf("поврежденная цепочка мини-секторов")
+		}
+		visited[sector] = true
+		offset := int(sector) * compoundMiniSectorSize
+		if offset+compoundMiniSectorSize > len(r.miniStream) {
+			return nil, fmt.Errorf("мини-сектор за пределами мини-потока")
+		}
+		data = append(data, r.miniStream[offset:offset+compoundMiniSectorSize]...)
+		sector = r.miniFAT[sector]
+	}
+	return data[:entry.size], nil
+}
+
+func (r *compoundReader) sector(sector uint32) ([]byte, error) {
+	offset := (int64(sector) + 1) * int64(r.sectorSize)
+	if offset+int64(r.sectorSize) > int64(len(r.data)) {
+		if offset < int64(len(r.data)) {
+			padded := make([]byte, r.sectorSize)
+			copy(padded, r.data[offset:])
+			return padded, nil
+		}
+		return nil, fmt.Errorf("сектор %d за пределами файла", sector)
+	}
+	return r.data[offset : offset+int64(r.sectorSize)], nil
+}
+
+func (r *compoundReader) readChain(start uint32, size uint64) ([]byte, error) {
+	var data []byte
+	visited := make(map[uint32]bool)
+	for sector := start; sector <= compoundMaxRegularSector; {
+		if visited[sector] || int(sector) >= len(r.fat) {
+			return nil, fmt.Errorf("поврежденная цепочка секторов")
+		}
+		visited[sector] = true
+		content, err := r.sector(sector)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, content...)
+		if size > 0 && uint64(len(data)) >= size {
+			break
+		}
+		sector = r.fat[sector]
+	}
+	if size > 0 {
+		if uint64(len(data)) < size {
+			return nil, fmt.Errorf("поток короче заявленного размера")
+		}
+		data = data[:size]
+	}
+	return data, nil
+}
+
+func readUint32s(data []byte) []uint32 {
+	values := make([]uint32, len(data)/4)
+	for i := range values {
+		values[i] = binary.LittleEndian.Uint32(data[4*i:])
+	}
+	return values
+}
+
+type compoundWriter struct {
+	body []byte
+	fat  []uint32
+}
+
+func (w *compoundWriter) allocate(data []byte, padding byte) uint32 {
+	if len(data) == 0 {
+		return compoundEndOfChain
+	}
+	start := uint32(len(w.fat))
+	count := (len(data) + compoundSectorSize - 1) / compoundSectorSize
+	for i := 0; i < count; i++ {
+		next := uint32(len(w.fat) + 1)
+		if i == count-1 {
+			next = compoundEndOfChain
+		}
+		w.fat = append(w.fat, next)
+	}
+	w.body = append(w.body, data...)
+	for len(w.body)%compoundSectorSize != 0 {
+		w.body = append(w.body, padding)
+	}
+	return start
+}
+
+func WriteCompoundFile(root *CompoundEntry) []byte {
+	entries := []*CompoundEntry{root}
+	records := []compoundDirEntry{{name: root.Name, kind: compoundEntryRoot, clsid: root.CLSID, left: compoundNoStream, right: compoundNoStream}}
+	var assign func(parent int, entry *CompoundEntry)
+	assign = func(parent int, entry *CompoundEntry) {
+		children := append([]*CompoundEntry(nil), entry.Children...)
+		sort.SliceStable(children, func(i, j int) bool { return compareCompoundNames(children[i].Name, children[j].Name) < 0 })
+		var ids []uint32
+		for _, child := range children {
+			kind := byte(compoundEntryStream)
+			if child.Storage {
+				kind = compoundEntryStorage
+			}
+			ids = append(ids, uint32(len(entries)))
+			entries = append(entries, child)
+			records = append(records, compoundDirEntry{name: child.Name, kind: kind, clsid: child.CLSID, left: compoundNoStream, right: compoundNoStream, child: compoundNoStream})
+		}
+		records[parent].child = balanceCompoundSiblings(records, ids)
+		for i, child := range children {
+			if child.Storage {
+				assign(int(ids[i]), child)
+			}
+		}
+	}
+	assign(0, root)
+
+	w := &compoundWriter{}
+	var miniStream []byte
+	var miniFAT []uint32
+	for i, entry := range entries {
+		if entry.Storage || i == 0 {
+			continue
+		}
+		records[i].size = uint64(len(entry.Data))
+		switch {
+		case len(entry.Data) == 0:
+			records[i].start = compoundEndOfChain
+		case len(entry.Data) >= compoundMiniStreamLimit:
+			records[i].start = w.allocate(entry.Data, 0)
+		default:
+			records[i].start = uint32(len(miniFAT))
+			count := (len(entry.Data) + compoundMiniSectorSize - 1) / compoundMiniSectorSize
+			for j := 0; j < count; j++ {
+				next := uint32(len(miniFAT) + 1)
+				if j == count-1 {
+					next = compoundEndOfChain
+				}
+				miniFAT = append(miniFAT, next)
+			}
+			miniStream = append(miniStream, entry.Data...)
+			for len(miniStream)%compoundMiniSectorSize != 0 {
+				miniStream = append(miniStream, 0)
+			}
+		}
+	}
+
+	records[0].start = w.allocate(miniStream, 0)
+	records[0].size = uint64(len(miniStream))
+	if len(miniStream) == 0 {
+		records[0].start = compoundEndOfChain
+	}
+
+	var miniFATBytes []byte
+	for _, value := range miniFAT {
+		miniFATBytes = binary.LittleEndian.AppendUint32(miniFATBytes, value)
+	}
+	for len(miniFATBytes)%compoundSectorSize != 0 {
+		miniFATBytes = binary.LittleEndian.AppendUint32(miniFATBytes, compoundFreeSector)
+	}
+	miniFATStart := w.allocate(miniFATBytes, 0)
+
+	var directory []byte
+	for _, record := range records {
+		directory = append(directory, record.bytes()...)
+	}
+	for len(directory)%compoundSectorSize != 0 {
+		directory = append(directory, compoundDirEntry{left: compoundNoStream, right: compoundNoStream, child: compoundNoStream}.bytes()...)
+	}
+	directoryStart := w.allocate(directory, 0)
+
+	perSector := compoundSectorSize / 4
+	fatCount, difatCount := 0, 0
+	for {
+		needed := (len(w.fat) + fatCount + difatCount + perSector - 1) / perSector
+		difatNeeded := 0
+		if needed > compoundHeaderDIFATSize {
+			difatNeeded = (needed - compoundHeaderDIFATSize + perSector - 2) / (perSector - 1)
+		}
+		if needed == fatCount && difatNeeded == difatCount {
+			break
+		}
+		fatCount, difatCount = needed, difatNeeded
+	}
+
+	var fatSectors []uint32
+	for i := 0; i < fatCount; i++ {
+		fatSectors = append(fatSectors, uint32(len(w.fat)))
+		w.fat = append(w.fat, compoundFATSector)
+	}
+	var difatSectors []uint32
+	for i := 0; i < difatCount; i++ {
+		difatSectors = append(difatSectors, uint32(len(w.fat)))
+		w.fat = append(w.fat, compoundDIFATSector)
+	}
+	for len(w.fat)%perSector != 0 {
+		w.fat = append(w.fat, compoundFreeSector)
+	}
+	for _, value := range w.fat {
+		w.body = binary.LittleEndian.AppendUint32(w.body, value)
+	}
+	for i, remaining := 0, fatSectors[min(len(fatSectors), compoundHeaderDIFATSize):]; i < difatCount; i++ {
+		var sector []byte
+		for j := 0; j < perSector-1; j++ {
+			value := uint32(compoundFreeSector)
+			if j < len(remaining) {
+				value = remaining[j]
+			}
+			sector = binary.LittleEndian.AppendUint32(sector, value)
+		}
+		remaining = remaining[min(len(remaining), perSector-1):]
+		next := uint32(compoundEndOfChain)
+		if i < difatCount-1 {
+			next = difatSectors[i+1]
+		}
+		w.body = append(w.body, binary.LittleEndian.AppendUint32(sector, next)...)
+	}
+
+	header := make([]byte, compoundHeaderSize)
+	copy(header, compoundFileSignature)
+	binary.LittleEndian.PutUint16(header[0x18:], 0x003E)
+	binary.LittleEndian.PutUint16(header[0x1A:], 0x0003)
+	binary.LittleEndian.PutUint16(header[0x1C:], 0xFFFE)
+	binary.LittleEndian.PutUint16(header[0x1E:], compoundSectorShift)
+	binary.LittleEndian.PutUint16(header[0x20:], compoundMiniSectorShift)
+	binary.LittleEndian.PutUint32(header[0x2C:], uint32(fatCount))
+	binary.LittleEndian.PutUint32(header[0x30:], directoryStart)
+	binary.LittleEndian.PutUint32(header[0x38:], compoundMiniStreamLimit)
+	binary.LittleEndian.PutUint32(header[0x3C:], miniFATStart)
+	binary.LittleEndian.PutUint32(header[0x40:], uint32(len(miniFATBytes)/compoundSectorSize))
+	firstDIFAT := uint32(compoundEndOfChain)
+	if difatCount > 0 {
+		firstDIFAT = difatSectors[0]
+	}
+	binary.LittleEndian.PutUint32(header[0x44:], firstDIFAT)
+	binary.LittleEndian.PutUint32(header[0x48:], uint32(difatCount))
+	for i := 0; i < compoundHeaderDIFATSize; i++ {
+		value := uint32(compoundFreeSector)
+		if i < len(fatSectors) {
+			value = fatSectors[i]
+		}
+		binary.LittleEndian.PutUint32(header[0x4C+4*i:], value)
+	}
+	return append(header, w.body...)
+}
+
+func (e compoundDirEntry) bytes() []byte {
+	raw := make([]byte, compoundDirEntrySize)
+	if e.kind != compoundEntryEmpty {
+		units := utf16.Encode([]rune(e.name))
+		if len(units) > 31 {
+			units = units[:31]
+		}
+		for i, unit := range units {
+			binary.LittleEndian.PutUint16(raw[2*i:], unit)
+		}
+		binary.LittleEndian.PutUint16(raw[64:], uint16(2*(len(units)+1)))
+		raw[67] = 1
+	}
+	raw[66] = e.kind
+	binary.LittleEndian.PutUint32(raw[68:], e.left)
+	binary.LittleEndian.PutUint32(raw[72:], e.right)
+	binary.LittleEndian.PutUint32(raw[76:], e.child)
+	copy(raw[80:96], e.clsid[:])
+	if e.kind == compoundEntryStream || e.kind == compoundEntryRoot {
+		binary.LittleEndian.PutUint32(raw[116:], e.start)
+		binary.LittleEndian.PutUint64(raw[120:], e.size)
+	}
+	return raw
+}
+
+func balanceCompoundSiblings(records []compoundDirEntry, ids []uint32) uint32 {
+	if len(ids) == 0 {
+		return compoundNoStream
+	}
+	middle := len(ids) / 2
+	id := ids[middle]
+	records[id].left = balanceCompoundSiblings(records, ids[:middle])
+	records[id].right = balanceCompoundSiblings(records, ids[middle+1:])
+	return id
+}
+
+func compareCompoundNames(a, b string) int {
+	unitsA, unitsB := utf16.Encode([]rune(strings.ToUpper(a))), utf16.Encode([]rune(strings.ToUpper(b)))
+	if len(unitsA) != len(unitsB) {
+		return len(unitsA) - len(unitsB)
+	}
+	for i := range unitsA {
+		if unitsA[i] != unitsB[i] {
+			return int(unitsA[i]) - int(unitsB[i])
+		}
+	}
+	return 0
+}
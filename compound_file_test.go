@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func testCompoundFile() []byte {
+	return WriteCompoundFile(&CompoundEntry{Name: "Root Entry", Storage: true, Children: []*CompoundEntry{
+		{Name: "Small", Data: []byte("маленький поток")},
+		{Name: "Large", Data: bytes.Repeat([]byte{1, 2, 3}, 3000)},
+		{Name: "Storage", Storage: true, Children: []*CompoundEntry{{Name: "Inner", Data: []byte("inner")}}},
+	}})
+}
+
+func compoundFATOffset(data []byte, sector uint32) int {
+	fatSector := binary.LittleEndian.Uint32(data[0x4C:])
+	return int(fatSector+1)*compoundSectorSize + 4*int(sector)
+}
+
+func compoundEntryOffset(t *testing.T, data []byte, name string) int {
+	directoryStart := binary.LittleEndian.Uint32(data[0x30:])
+	for id := 0; id < compoundSectorSize/compoundDirEntrySize; id++ {
+		offset := int(directoryStart+1)*compoundSectorSize + id*compoundDirEntrySize
+		if parseCompoundDirEntry(data[offset:offset+compoundDirEntrySize], true).name == name {
+			return offset
+		}
+	}
+	t.Fatalf("элемент каталога %q не найден", name)
+	return 0
+}
+
+func TestCompoundFileRoundTrip(t *testing.T) {
+	root, err := ReadCompoundFile(testCompoundFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		path []string
+		want []byte
+	}{
+		{[]string{"Small"}, []byte("маленький поток")},
+		{[]string{"Large"}, bytes.Repeat([]byte{1, 2, 3}, 3000)},
+		{[]string{"Storage", "Inner"}, []byte("inner")},
+	} {
+		got, ok := root.Stream(tc.path...)
+		if !ok || !bytes.Equal(got, tc.want) {
+			t.Errorf("поток %v прочитан неверно", tc.path)
+		}
+	}
+}
+
+func TestCompoundFileHostileInput(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		mutate func(t *testing.T, data []byte)
+	}{
+		{"цикл в цепочке каталога", func(t *testing.T, data []byte) {
+			directoryStart := binary.LittleEndian.Uint32(data[0x30:])
+			binary.LittleEndian.PutUint32(data[compoundFATOffset(data, directoryStart):], directoryStart)
+		}},
+		{"цикл в цепочке потока", func(t *testing.T, data []byte) {
+			start := binary.LittleEndian.Uint32(data[compoundEntryOffset(t, data, "Large")+116:])
+			binary.LittleEndian.PutUint32(data[compoundFATOffset(data, start)+4:], start)
+		}},
+		{"идентификатор дочернего элемента вне каталога", func(t *testing.T, data []byte) {
+			binary.LittleEndian.PutUint32(data[compoundEntryOffset(t, data, "Root Entry")+76:], 1000)
+		}},
+		{"идентификатор соседнего элемента вне каталога", func(t *testing.T, data []byte) {
+			binary.LittleEndian.PutUint32(data[compoundEntryOffset(t, data, "Small")+72:], 0xFFFFFF00)
+		}},
+		{"цикл в дереве каталога", func(t *testing.T, data []byte) {
+			binary.LittleEndian.PutUint32(data[compoundEntryOffset(t, data, "Storage")+68:], 1)
+			binary.LittleEndian.PutUint32(data[compoundEntryOffset(t, data, "Large")+72:], 3)
+		}},
+		{"сектор FAT за пределами файла", func(t *testing.T, data []byte) {
+			binary.LittleEndian.PutUint32(data[0x4C:], 0x00FFFFFF)
+		}},
+		{"мини-сектор за пределами мини-потока", func(t *testing.T, data []byte) {
+			binary.LittleEndian.PutUint32(data[compoundEntryOffset(t, data, "Small")+116:], 1000)
+		}},
+		{"поток длиннее файла", func(t *testing.T, data []byte) {
+			binary.LittleEndian.PutUint64(data[compoundEntryOffset(t, data, "Large")+120:], 1<<30)
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := testCompoundFile()
+			tc.mutate(t, data)
+			if _, err := ReadCompoundFile(data); err == nil {
+				t.Fatal("ожидалась ошибка чтения поврежденного файла")
+			}
+		})
+	}
+}
+
+func TestCompoundFileTruncated(t *testing.T) {
+	data := testCompoundFile()
+	for n := 0; n < len(data); n += 61 {
+		ReadCompoundFile(data[:n])
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+const (
+	contentTypesNS       = "http://schemas.openxmlformats.org/package/2006/content-types"
+	contentTypesPartName = "[Content_Types].xml"
+)
+
+var wordMainContentTypes = map[string]bool{
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml": true,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.template.main+xml": true,
+	"application/vnd.ms-word.document.macroEnabled.main+xml":                           true,
+	"application/vnd.ms-word.template.macroEnabledTemplate.main+xml":                   true,
+}
+
+type ContentTypes struct {
+	doc *etree.Document
+}
+
+func ParseContentTypes(content []byte) (*ContentTypes, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(content); err != nil {
+		return nil, err
+	}
+	if doc.Root() == nil {
+		return nil, fmt.Errorf("пустой файл типов содержимого")
+	}
+	return &ContentTypes{doc: doc}, nil
+}
+
+func (ct *ContentTypes) ContentType(partName string) string {
+	partName = "/" + strings.TrimPrefix(partName, "/")
+	for _, override := range ct.doc.Root().SelectElements("Override") {
+		if strings.EqualFold(override.SelectAttrValue("PartName", ""), partName) {
+			return override.SelectAttrValue("ContentType", "")
+		}
+	}
+	ext := strings.TrimPrefix(path.Ext(partName), ".")
+	return ct.defaultContentType(ext)
+}
+
+func (ct *ContentTypes) PartNameForContentType(contentTypes map[string]bool) string {
+	for _, override := range ct.doc.Root().SelectElements("Override") {
+		if contentTypes[override.SelectAttrValue("ContentType", "")] {
+			return strings.TrimPrefix(override.SelectAttrValue("PartName", ""), "/")
+		}
+	}
+	return ""
+}
+
+func (ct *ContentTypes) defaultContentType(ext string) string {
+	for _, def := range ct.doc.Root().SelectElements("Default") {
+		if strings.EqualFold(def.SelectAttrValue("Extension", ""), ext) {
+			return def.SelectAttrValue("ContentType", "")
+		}
+	}
+	return ""
+}
+
+func (ct *ContentTypes) AddDefault(ext, contentType string) {
+	if ext == "" || ct.defaultContentType(ext) != "" {
+		return
+	}
+	def := etree.NewElement("Default")
+	def.CreateAttr("Extension", ext)
+	def.CreateAttr("ContentType", contentType)
+
+	root := ct.doc.Root()
+	if overrides := root.SelectElements("Override"); len(overrides) > 0 {
+		root.InsertChild(overrides[0], def)
+	} else {
+		root.AddChild(def)
+	}
+}
+
+func (ct *ContentTypes) SetOverride(partName, contentType string) {
+	partName = "/" + strings.TrimPrefix(partName, "/")
+	root := ct.doc.Root()
+	for _, override := range root.SelectElements("Override") {
+		if strings.EqualFold(override.SelectAttrValue("PartName", ""), partName) {
+			override.CreateAttr("ContentType", contentType)
+			return
+		}
+	}
+	override := root.CreateElement("Override")
+	override.CreateAttr("PartName", partName)
+	override.CreateAttr("ContentType", contentType)
+}
+
+func (ct *ContentTypes) RemoveOverride(partName string) {
+	partName = "/" + strings.TrimPrefix(partName, "/")
+	root := ct.doc.Root()
+	for _, override := range root.SelectElements("Override") {
+		if strings.EqualFold(override.SelectAttrValue("PartName", ""), partName) {
+			root.RemoveChild(override)
+		}
+	}
+}
+
+func (ct *ContentTypes) RemoveDefault(ext string) {
+	root := ct.doc.Root()
+	for _, def := range root.SelectElements("Default") {
+		if strings.EqualFold(def.SelectAttrValue("Extension", ""), ext) {
+			root.RemoveChild(def)
+		}
+	}
+}
+
+func (ct *ContentTypes) Bytes() ([]byte, error) {
+	return ct.doc.WriteToBytes()
+}
+
+func readContentTypes(pkg *Package) (*ContentTypes, error) {
+	content, err := pkg.ReadPart(contentTypesPartName)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения %s: %w", contentTypesPartName, err)
+	}
+	contentTypes, err := ParseContentTypes(content)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка парсинга %s: %w", contentTypesPartName, err)
+	}
+	return contentTypes, nil
+}
+
+func writeContentTypes(pkg *Package, contentTypes *ContentTypes) error {
+	content, err := contentTypes.Bytes()
+	if err != nil {
+		return err
+	}
+	pkg.WritePart(contentTypesPartName, content)
+	return nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+)
+
+const (
+	relTypeCoreProperties       = "http://schemas.openxmlformats.org/package/2006/relationships/metadata/core-properties"
+	legacyRelTypeCoreProperties = "http://schemas.openxmlformats.org/officedocument/2006/relationships/metadata/core-properties"
+	relTypeCustomProperties     = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/custom-properties"
+	corePropertiesContentType   = "application/vnd.openxmlformats-package.core-properties+xml"
+	customPropertiesContentType = "application/vnd.openxmlformats-officedocument.custom-properties+xml"
+	defaultCorePropertiesPart   = "docProps/core.xml"
+	defaultCustomPropertiesPart = "docProps/custom.xml"
+
+	corePropertiesNS         = "http://schemas.openxmlformats.org/package/2006/metadata/core-properties"
+	dublinCoreTermsNS        = "http://purl.org/dc/terms/"
+	xmlSchemaInstanceNS      = "http://www.w3.org/2001/XMLSchema-instance"
+	customPropertiesNS       = "http://schemas.openxmlformats.org/officeDocument/2006/custom-properties"
+	strictCustomPropertiesNS = "http://purl.oclc.org/ooxml/officeDocument/customProperties"
+	docPropsVTypesNS         = "http://schemas.openxmlformats.org/officeDocument/2006/docPropsVTypes"
+	strictDocPropsVTypesNS   = "http://purl.oclc.org/ooxml/officeDocument/docPropsVTypes"
+	customPropertyFormatID   = "{D5CDD505-2E9C-101B-9397-08002B2CF9AE}"
+	firstCustomPropertyID    = 2
+
+	stampVersionProperty = "DocxNumConvertVersion"
+	stampOptionsProperty = "DocxNumConvertOptions"
+)
+
+const (
+	ProcessedPolicyWarn = "warn"
+	ProcessedPolicySkip = "skip"
+)
+
+var Version = "dev"
+
+var ErrAlreadyProcessed = errors.New("документ уже обработан DocxNumConvert")
+
+func (dnp *DocxNumberingProcessor) checkProcessingStamp(pkg *Package) error {
+	version, found, err := readProcessingStamp(pkg)
+	if err != nil || !found {
+		return err
+	}
+	if dnp.ProcessedPolicy == ProcessedPolicySkip {
+		return fmt.Errorf("%w (версия %s)", ErrAlreadyProcessed, version)
+	}
+	dnp.addWarning("документ уже обработан DocxNumConvert (версия %s), повторная обработка не изменит нумерацию", version)
+	return nil
+}
+
+func (dnp *DocxNumberingProcessor) addWarning(format string, args ...interface{}) {
+	dnp.Warnings = append(dnp.Warnings, fmt.Sprintf(format, args...))
+}
+
+func (dnp *DocxNumberingProcessor) stampOptions() string {
+	options := []string{
+		"trackChanges=" + dnp.TrackChanges,
+		"recordRevisions=" + strconv.FormatBool(dnp.RecordRevisions),
+		"flattenFields=" + strconv.FormatBool(dnp.FlattenFields),
+		"regenerateTOC=" + strconv.FormatBool(dnp.RegenerateTOC),
+		"clauseBookmarks=" + strconv.FormatBool(dnp.ClauseBookmarks),
+		"inlineAltChunks=" + strconv.FormatBool(dnp.InlineAltChunks),
+		"directionMarks=" + strconv.FormatBool(dnp.DirectionMarks),
+		"numberingCleanup=" + dnp.NumberingCleanup,
+		"useCurrentTime=" + strconv.FormatBool(dnp.UseCurrentTime),
+		"streaming=" + strconv.FormatBool(dnp.Streaming),
+	}
+	if dnp.NumberRunStyle != "" {
+		options = append(options, "numberRunStyle="+dnp.NumberRunStyle)
+	}
+	return strings.Join(options, "; ")
+}
+
+func (dnp *DocxNumberingProcessor) modificationTime() time.Time {
+	if !dnp.ModifiedTime.IsZero() {
+		return dnp.ModifiedTime.UTC()
+	}
+	if dnp.UseCurrentTime {
+		return time.Now().UTC()
+	}
+	return dnp.sourceTime.UTC()
+}
+
+func readProcessingStamp(pkg *Package) (string, bool, error) {
+	partName, err := packagePartByType(pkg, relTypeCustomProperties)
+	if err != nil || partName == "" {
+		return "", false, err
+	}
+	content, err := pkg.ReadPart(partName)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(content); err != nil || doc.Root() == nil {
+		return "", false, nil
+	}
+	if property := findCustomProperty(doc.Root(), stampVersionProperty); property != nil {
+		for _, value := range property.ChildElements() {
+			return value.Text(), true, nil
+		}
+		return "", true, nil
+	}
+	return "", false, nil
+}
+
+func (dnp *DocxNumberingProcessor) updateDocumentProperties(pkg *Package) error {
+	contentTypes, err := readContentTypes(pkg)
+	if err != nil {
+		return err
+	}
+	packageRels, err := readPartRelationships(pkg, "")
+	if err != nil {
+		return err
+	}
+
+	coreDoc, corePartName, err := openPackagePart(pkg, packageRels, contentTypes, defaultCorePropertiesPart, relTypeCoreProperties, corePropertiesContentType, relTypeCoreProperties, legacyRelTypeCoreProperties)
+	if err != nil {
+		return err
+	}
+	if coreDoc.Root() == nil {
+		root := coreDoc.CreateElement("cp:coreProperties")
+		root.CreateAttr("xmlns:cp", corePropertiesNS)
+		root.CreateAttr("xmlns:dc", "http://purl.org/dc/elements/1.1/")
+		root.CreateAttr("xmlns:dcterms", dublinCoreTermsNS)
+		root.CreateAttr("xmlns:xsi", xmlSchemaInstanceNS)
+	}
+	coreRoot := coreDoc.Root()
+	setChildText(coreRoot, corePropertiesNS, "cp", "lastModifiedBy", dnp.RevisionAuthor)
+	modified := setChildText(coreRoot, dublinCoreTermsNS, "dcterms", "modified", dnp.modificationTime().Format(revisionDateLayout))
+	modified.CreateAttr(namespacePrefix(coreRoot, xmlSchemaInstanceNS, "xsi")+":type", namespacePrefix(coreRoot, dublinCoreTermsNS, "dcterms")+":W3CDTF")
+
+	customDoc, customPartName, err := openPackagePart(pkg, packageRels, contentTypes, defaultCustomPropertiesPart, relTypeCustomProperties, customPropertiesContentType, relTypeCustomProperties)
+	if err != nil {
+		return err
+	}
+	if customDoc.Root() == nil {
+		root := customDoc.CreateElement("Properties")
+		root.CreateAttr("xmlns", customPropertiesNS)
+		root.CreateAttr("xmlns:vt", docPropsVTypesNS)
+	}
+	setCustomProperty(customDoc.Root(), stampVersionProperty, Version)
+	setCustomProperty(customDoc.Root(), stampOptionsProperty, dnp.stampOptions())
+
+	partNames := []string{corePartName, customPartName}
+	for i, doc := range []*etree.Document{coreDoc, customDoc} {
+		output, err := doc.WriteToBytes()
+		if err != nil {
+			return fmt.Errorf("ошибка записи %s: %w", partNames[i], err)
+		}
+		pkg.WritePart(partNames[i], output)
+	}
+	if err := writePartRelationships(pkg, "", packageRels); err != nil {
+		return err
+	}
+	return writeContentTypes(pkg, contentTypes)
+}
+
+func packagePartByType(pkg *Package, relTypes ...string) (string, error) {
+	packageRels, err := readPartRelationships(pkg, "")
+	if err != nil {
+		return "", err
+	}
+	return relatedPackagePart(packageRels, relTypes...), nil
+}
+
+func relatedPackagePart(packageRels *Relationships, relTypes ...string) string {
+	for _, relType := range relTypes {
+		for _, rel := range packageRels.ByType(relType) {
+			if rel.TargetMode != targetModeExternal {
+				if partName := resolveRelationshipTarget("", rel.Target); partName != "" {
+					return partName
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func openPackagePart(pkg *Package, packageRels *Relationships, contentTypes *ContentTypes, defaultPartName, relType, contentType string, relTypes ...string) (*etree.Document, string, error) {
+	doc := etree.NewDocument()
+	partName := relatedPackagePart(packageRels, relTypes...)
+	if partName != "" {
+		content, err := pkg.ReadPart(partName)
+		if err == nil {
+			if err := doc.ReadFromBytes(content); err != nil {
+				return nil, "", fmt.Errorf("ошибка парсинга %s: %w", partName, err)
+			}
+			return doc, partName, nil
+		} else if !os.IsNotExist(err) {
+			return nil, "", err
+		}
+	} else {
+		partName = defaultPartName
+		packageRels.Add(relType, partName, "")
+	}
+	doc.CreateProcInst("xml", `version="1.0" encoding="UTF-8" standalone="yes"`)
+	contentTypes.SetOverride(partName, contentType)
+	return doc, partName, nil
+}
+
+func namespacePrefix(root *etree.Element, uri, preferred string) string {
+	for _, attr := range root.Attr {
+		if attr.Space == "xmlns" && attr.Value == uri {
+			return attr.Key
+		}
+	}
+	prefix := preferred
+	for n := 1; root.SelectAttr("xmlns:"+prefix) != nil; n++ {
+		prefix = preferred + strconv.Itoa(n)
+	}
+	root.CreateAttr("xmlns:"+prefix, uri)
+	return prefix
+}
+
+func setChildText(parent *etree.Element, uri, preferredPrefix, local, text string) *etree.Element {
+	for _, child := range parent.ChildElements() {
+		if child.Tag == local && child.NamespaceURI() == uri {
+			child.SetText(text)
+			return child
+		}
+	}
+	child := parent.CreateElement(namespacePrefix(parent, uri, preferredPrefix) + ":" + local)
+	child.SetText(text)
+	return child
+}
+
+func findCustomProperty(root *etree.Element, name string) *etree.Element {
+	for _, property := range root.ChildElements() {
+		if property.Tag == "property" && property.SelectAttrValue("name", "") == name {
+			return property
+		}
+	}
+	return nil
+}
+
+func setCustomProperty(root *etree.Element, name, value string) {
+	vtURI := docPropsVTypesNS
+	if root.NamespaceURI() == strictCustomPropertiesNS {
+		vtURI = strictDocPropsVTypesNS
+	}
+	vtPrefix := namespacePrefix(root, vtURI, "vt")
+
+	property := findCustomProperty(root, name)
+	if property == nil {
+		nextID := firstCustomPropertyID
+		for _, existing := range root.ChildElements() {
+			if id, err := strconv.Atoi(existing.SelectAttrValue("pid", "")); err == nil && id >= nextID {
+				nextID = id + 1
+			}
+		}
+		tag := "property"
+		if root.Space != "" {
+			tag = root.Space + ":" + tag
+		}
+		property = root.CreateElement(tag)
+		property.CreateAttr("fmtid", customPropertyFormatID)
+		property.CreateAttr("pid", strconv.Itoa(nextID))
+		property.CreateAttr("name", name)
+	}
+	for _, child := range property.ChildElements() {
+		property.RemoveChild(child)
+	}
+	property.CreateElement(vtPrefix + ":lpwstr").SetText(value)
+}
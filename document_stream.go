@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+const (
+	streamParagraphLimit = 16 << 20
+	streamFlushThreshold = 64 << 10
+	streamWrapperTag     = "docxNumConvertStream"
+)
+
+var errParagraphTooLarge = errors.New("абзац слишком велик для потоковой обработки")
+
+type streamInput struct {
+	r    io.Reader
+	data []byte
+	base int64
+}
+
+func (s *streamInput) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.data = append(s.data, p[:n]...)
+	return n, err
+}
+
+func (s *streamInput) slice(start, end int64) []byte {
+	return s.data[start-s.base : end-s.base]
+}
+
+func (s *streamInput) discard(offset int64) {
+	n := copy(s.data, s.data[offset-s.base:])
+	s.data = s.data[:n]
+	s.base = offset
+}
+
+type documentStream struct {
+	numberer *paragraphNumberer
+	limiter  *packageLimiter
+	partName string
+
+	input          *streamInput
+	output         io.Writer
+	written        int64
+	scopes         [][]xml.Attr
+	wrapper        string
+	wrapperVersion int
+	scopeVersion   int
+	elements       int
+	numIDs         map[string]bool
+	done           bool
+}
+
+func (dnp *DocxNumberingProcessor) streamingConflicts() []string {
+	var conflicts []string
+	if dnp.TrackChanges != TrackChangesAll {
+		conflicts = append(conflicts, "режим исправлений "+dnp.TrackChanges)
+	}
+	if dnp.FlattenFields {
+		conflicts = append(conflicts, "замена полей")
+	}
+	if dnp.RegenerateTOC {
+		conflicts = append(conflicts, "пересборка оглавления")
+	}
+	if dnp.ClauseBookmarks {
+		conflicts = append(conflicts, "закладки пунктов")
+	}
+	return conflicts
+}
+
+func (dnp *DocxNumberingProcessor) useStreaming() bool {
+	if !dnp.Streaming {
+		return false
+	}
+	if conflicts := dnp.streamingConflicts(); len(conflicts) > 0 {
+		dnp.addWarning("потоковая обработка несовместима с параметрами (%s), документ обработан через DOM", strings.Join(conflicts, ", "))
+		return false
+	}
+	return true
+}
+
+func (dnp *DocxNumberingProcessor) newDocumentStream(pkg *Package, partName string) (*documentStream, error) {
+	var recorder *revisionRecorder
+	if dnp.RecordRevisions {
+		maxID, err := scanMaxRevisionID(pkg, partName)
+		if err != nil {
+			return nil, err
+		}
+		recorder = dnp.revisionRecorderAfter(maxID)
+	}
+	numberer := dnp.newParagraphNumberer(dnp.NumberingParser.NumberingDefinitions, dnp.StyleSheet, recorder)
+	numberer.numbers = nil
+
+	return &documentStream{
+		numberer:       numberer,
+		limiter:        dnp.limiter,
+		partName:       partName,
+		numIDs:         make(map[string]bool),
+		wrapperVersion: -1,
+	}, nil
+}
+
+func (ds *documentStream) process(r io.Reader, w io.Writer) error {
+	ds.input = &streamInput{r: r}
+	ds.output = w
+	if err := ds.run(); err != nil {
+		return fmt.Errorf("ошибка обработки %s: %w", ds.partName, err)
+	}
+	ds.done = true
+	return nil
+}
+
+func scanMaxRevisionID(pkg *Package, partName string) (int, error) {
+	rc, err := pkg.PeekPart(partName)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+
+	maxID := 0
+	decoder := xml.NewDecoder(rc)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return maxID, nil
+		} else if err != nil {
+			return 0, err
+		}
+		element, ok := token.(xml.StartElement)
+		if !ok || !isNamespaceInPrefix(element.Name.Space, wordProcessingMLPrefix) {
+			continue
+		}
+		for _, attr := range element.Attr {
+			if attr.Name.Local != "id" || (attr.Name.Space != "" && !isNamespaceInPrefix(attr.Name.Space, wordProcessingMLPrefix)) {
+				continue
+			}
+			if n, err := strconv.Atoi(attr.Value); err == nil && n > maxID {
+				maxID = n
+			}
+		}
+	}
+}
+
+func (ds *documentStream) run() error {
+	decoder := xml.NewDecoder(ds.input)
+	paragraphStart := int64(-1)
+	paragraphDepth := 0
+	for {
+		offset := decoder.InputOffset()
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			ds.elements++
+			if err := ds.limiter.checkElements(ds.elements, ds.partName); err != nil {
+				return err
+			}
+			if paragraphDepth > 0 {
+				paragraphDepth++
+				break
+			}
+			ds.pushScope(t.Attr)
+			if t.Name.Local == "p" && isNamespaceInPrefix(ds.resolve(t.Name.Space), wordProcessingMLPrefix) {
+				if err := ds.flush(offset); err != nil {
+					return err
+				}
+				paragraphStart, paragraphDepth = offset, 1
+			}
+		case xml.EndElement:
+			if paragraphDepth > 0 {
+				if paragraphDepth--; paragraphDepth > 0 {
+					break
+				}
+				end := decoder.InputOffset()
+				if err := ds.writeParagraph(ds.input.slice(paragraphStart, end)); err != nil {
+					return err
+				}
+				ds.written = end
+				ds.input.discard(end)
+			}
+			ds.popScope()
+		}
+
+		if paragraphDepth > 0 {
+			if decoder.InputOffset()-paragraphStart > streamParagraphLimit {
+				return errParagraphTooLarge
+			}
+		} else if decoder.InputOffset()-ds.written > streamFlushThreshold {
+			if err := ds.flush(decoder.InputOffset()); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := ds.output.Write(ds.input.slice(ds.written, ds.input.base+int64(len(ds.input.data))))
+	return err
+}
+
+func (ds *documentStream) flush(offset int64) error {
+	if _, err := ds.output.Write(ds.input.slice(ds.written, offset)); err != nil {
+		return err
+	}
+	ds.written = offset
+	ds.input.discard(offset)
+	return nil
+}
+
+func (ds *documentStream) pushScope(attrs []xml.Attr) {
+	var declarations []xml.Attr
+	for _, attr := range attrs {
+		if attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+			declarations = append(declarations, attr)
+		}
+	}
+	if len(declarations) > 0 {
+		ds.scopeVersion++
+	}
+	ds.scopes = append(ds.scopes, declarations)
+}
+
+func (ds *documentStream) popScope() {
+	if len(ds.scopes) == 0 {
+		return
+	}
+	if len(ds.scopes[len(ds.scopes)-1]) > 0 {
+		ds.scopeVersion++
+	}
+	ds.scopes = ds.scopes[:len(ds.scopes)-1]
+}
+
+func (ds *documentStream) resolve(prefix string) string {
+	for i := len(ds.scopes) - 1; i >= 0; i-- {
+		for _, attr := range ds.scopes[i] {
+			if (prefix == "" && attr.Name.Space == "") || (prefix != "" && attr.Name.Space == "xmlns" && attr.Name.Local == prefix) {
+				return attr.Value
+			}
+		}
+	}
+	return ""
+}
+
+func (ds *documentStream) wrapperStart() string {
+	if ds.wrapperVersion == ds.scopeVersion {
+		return ds.wrapper
+	}
+	seen := make(map[string]bool)
+	var builder strings.Builder
+	builder.WriteString("<" + streamWrapperTag)
+	for i := len(ds.scopes) - 1; i >= 0; i-- {
+		for _, attr := range ds.scopes[i] {
+			key := attr.Name.Local
+			if attr.Name.Space == "xmlns" {
+				key = "xmlns:" + key
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			builder.WriteString(" " + key + `="`)
+			xml.EscapeText(&builder, []byte(attr.Value))
+			builder.WriteString(`"`)
+		}
+	}
+	builder.WriteString(">")
+	ds.wrapper, ds.wrapperVersion = builder.String(), ds.scopeVersion
+	return ds.wrapper
+}
+
+func (ds *documentStream) writeParagraph(raw []byte) error {
+	wrapperStart := ds.wrapperStart()
+	content := make([]byte, 0, len(wrapperStart)+len(raw)+len(streamWrapperTag)+3)
+	content = append(content, wrapperStart...)
+	content = append(content, raw...)
+	content = append(content, "</"+streamWrapperTag+">"...)
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(content); err != nil {
+		return fmt.Errorf("ошибка разбора абзаца: %w", err)
+	}
+	paragraph := doc.Root().ChildElements()[0]
+	before := elementDigest(paragraph, &doc.WriteSettings)
+
+	CleanDocument(paragraph)
+	for _, nested := range findAllElements(doc.Root(), ".//w:p") {
+		ds.numberer.numberParagraph(nested)
+	}
+	for _, numID := range findAllElements(paragraph, ".//w:numId") {
+		if val, ok := getAttribute(numID, "val"); ok && val != "0" {
+			ds.numIDs[val] = true
+		}
+	}
+
+	var output bytes.Buffer
+	writeElement(&output, paragraph, &doc.WriteSettings)
+	if after := sha256.Sum256(output.Bytes()); bytes.Equal(after[:], before) {
+		_, err := ds.output.Write(raw)
+		return err
+	}
+	_, err := ds.output.Write(output.Bytes())
+	return err
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+const testNumberingXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:numbering xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:abstractNum w:abstractNumId="0"><w:lvl w:ilvl="0"><w:start w:val="1"/><w:numFmt w:val="decimal"/><w:lvlText w:val="%1."/></w:lvl><w:lvl w:ilvl="1"><w:start w:val="1"/><w:numFmt w:val="decimal"/><w:lvlText w:val="%1.%2."/></w:lvl></w:abstractNum><w:num w:numId="1"><w:abstractNumId w:val="0"/></w:num><w:num w:numId="2"><w:abstractNumId w:val="0"/></w:num></w:numbering>`
+
+func testNumberedDocument(paragraphs int) []byte {
+	var builder strings.Builder
+	builder.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	builder.WriteString(`<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>`)
+	for i := 0; i < paragraphs; i++ {
+		fmt.Fprintf(&builder, `<w:p><w:pPr><w:numPr><w:ilvl w:val="%d"/><w:numId w:val="1"/></w:numPr></w:pPr><w:r><w:t>Пункт %d</w:t></w:r></w:p>`, i%2, i)
+	}
+	builder.WriteString(`<w:sectPr/></w:body></w:document>`)
+	return []byte(builder.String())
+}
+
+type testPart struct{ name, content string }
+
+func testNumberedParts(document []byte) []testPart {
+	return []testPart{
+		{contentTypesPartName, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/><Override PartName="/word/numbering.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.numbering+xml"/></Types>`},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/></Relationships>`},
+		{"word/numbering.xml", testNumberingXML},
+		{"word/document.xml", string(document)},
+		{"word/_rels/document.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/numbering" Target="numbering.xml"/></Relationships>`},
+	}
+}
+
+func testPackage(t testing.TB, parts []testPart) []byte {
+	var buf bytes.Buffer
+	zipWriter := zip.NewWriter(&buf)
+	for _, part := range parts {
+		writer, err := zipWriter.Create(part.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write([]byte(part.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testNumberedPackage(t testing.TB, document []byte) []byte {
+	return testPackage(t, testNumberedParts(document))
+}
+
+func testStreamingProcessor(t testing.TB) *DocxNumberingProcessor {
+	processor := NewDocxNumberingProcessor()
+	processor.FlattenFields = false
+	if err := processor.NumberingParser.ParseNumberingXML([]byte(testNumberingXML)); err != nil {
+		t.Fatal(err)
+	}
+	return processor
+}
+
+func readPackagePart(t testing.TB, data []byte, name string) []byte {
+	pkg, err := OpenPackage(bytes.NewReader(data), int64(len(data)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := pkg.ReadPart(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestDocumentStreamMatchesDOM(t *testing.T) {
+	document := testNumberedDocument(200)
+
+	expected, err := testStreamingProcessor(t).processDocument(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkg := NewPackage()
+	pkg.WritePart(defaultMainDocumentPartName, document)
+	stream, err := testStreamingProcessor(t).newDocumentStream(pkg, defaultMainDocumentPartName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output bytes.Buffer
+	if err := stream.process(bytes.NewReader(document), &output); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output.Bytes(), expected) {
+		t.Fatal("результат потоковой обработки отличается от обработки через DOM")
+	}
+}
+
+func TestStreamingPackageOutput(t *testing.T) {
+	document := testNumberedDocument(2000)
+	input := testNumberedPackage(t, document)
+
+	processor := NewDocxNumberingProcessor()
+	processor.FlattenFields = false
+	var expected bytes.Buffer
+	if err := processor.ProcessStream(bytes.NewReader(input), int64(len(input)), &expected); err != nil {
+		t.Fatal(err)
+	}
+
+	processor = NewDocxNumberingProcessor()
+	processor.FlattenFields = false
+	processor.Streaming = true
+	processor.RecordRevisions = true
+	processor.Limits.MaxTotalSize = int64(len(document)) + 4096
+	var output bytes.Buffer
+	if err := processor.ProcessStream(bytes.NewReader(input), int64(len(input)), &output); err != nil {
+		t.Fatalf("документ должен учитываться в лимите один раз: %v", err)
+	}
+	if !bytes.Contains(readPackagePart(t, output.Bytes(), defaultMainDocumentPartName), []byte("w:pPrChange")) {
+		t.Fatal("исправления не записаны")
+	}
+
+	processor.RecordRevisions = false
+	processor.Limits = DefaultLimits()
+	output.Reset()
+	if err := processor.ProcessStream(bytes.NewReader(input), int64(len(input)), &output); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{defaultMainDocumentPartName, "word/numbering.xml"} {
+		if !bytes.Equal(readPackagePart(t, output.Bytes(), name), readPackagePart(t, expected.Bytes(), name)) {
+			t.Errorf("%s отличается от результата обработки через DOM", name)
+		}
+	}
+}
+
+func BenchmarkProcessDocument(b *testing.B) {
+	document := testNumberedDocument(20000)
+	processor := testStreamingProcessor(b)
+	b.SetBytes(int64(len(document)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := processor.processDocument(document); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProcessDocumentStream(b *testing.B) {
+	document := testNumberedDocument(20000)
+	processor := testStreamingProcessor(b)
+	pkg := NewPackage()
+	pkg.WritePart(defaultMainDocumentPartName, document)
+	b.SetBytes(int64(len(document)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		stream, err := processor.newDocumentStream(pkg, defaultMainDocumentPartName)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := stream.process(bytes.NewReader(document), io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
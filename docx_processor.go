@@ -1,246 +1,407 @@
 package main
 
 import (
-	"archive/zip"
+	"bytes"
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
-	"strings"
+	"time"
 
 	"github.com/beevik/etree"
 )
 
 type DocxNumberingProcessor struct {
-	NumberingParser *NumberingParser
+	NumberingParser  *NumberingParser
+	InlineAltChunks  bool
+	OutputFormat     string
+	TrackChanges     string
+	RecordRevisions  bool
+	RevisionAuthor   string
+	RevisionDate     time.Time
+	NumberRunStyle   string
+	DirectionMarks   bool
+	FlattenFields    bool
+	RegenerateTOC    bool
+	KeepTOCPages     bool
+	ClauseBookmarks  bool
+	BookmarkPrefix   string
+	StyleSheet       *StyleSheet
+	NumberingCleanup string
+	StampDocument    bool
+	ProcessedPolicy  string
+	ModifiedTime     time.Time
+	UseCurrentTime   bool
+	Streaming        bool
+	SignaturePolicy  string
+	Password         string
+	ReEncrypt        bool
+	Limits           Limits
+	NoClobber        bool
+	Backup           string
+	BackupPath       string
+	Warnings         []string
+
+	limiter      *packageLimiter
+	nestingDepth int
+	sourceTime   time.Time
 }
 
 func NewDocxNumberingProcessor() *DocxNumberingProcessor {
 	return &DocxNumberingProcessor{
-		NumberingParser: NewNumberingParser(),
+		NumberingParser:  NewNumberingParser(),
+		TrackChanges:     TrackChangesAll,
+		RevisionAuthor:   DefaultRevisionAuthor,
+		FlattenFields:    true,
+		KeepTOCPages:     true,
+		BookmarkPrefix:   DefaultClauseBookmarkPrefix,
+		StyleSheet:       NewStyleSheet(),
+		NumberingCleanup: NumberingCleanupUnused,
+		StampDocument:    true,
+		ProcessedPolicy:  ProcessedPolicyWarn,
+		SignaturePolicy:  SignaturePolicyWarn,
+		Limits:           DefaultLimits(),
+		Backup:           BackupBak,
 	}
 }
 
 func (dnp *DocxNumberingProcessor) Process(inputDocxPath, outputDocxPath string) (bool, error) {
-	tempDir := inputDocxPath + "_temp"
-
-	if _, err := os.Stat(tempDir); err == nil {
-		if err := os.RemoveAll(tempDir); err != nil {
-			return false, fmt.Errorf("ошибка удаления временной директории: %w", err)
-		}
+	inputFile, err := os.Open(inputDocxPath)
+	if err != nil {
+		return false, fmt.Errorf("ошибка открытия файла: %w", err)
 	}
+	defer inputFile.Close()
 
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		return false, fmt.Errorf("ошибка создания временной директории: %w", err)
+	info, err := inputFile.Stat()
+	if err != nil {
+		return false, fmt.Errorf("ошибка открытия файла: %w", err)
 	}
 
-	defer os.RemoveAll(tempDir)
-
-	if err := unzip(inputDocxPath, tempDir); err != nil {
-		return false, fmt.Errorf("ошибка распаковки DOCX: %w", err)
+	inPlace := isSameFile(inputDocxPath, outputDocxPath)
+	if inPlace && !dnp.ReEncrypt {
+		if inputFormat, err := detectInputFormat(inputFile, info.Size()); err == nil && inputFormat == InputFormatCompound {
+			return false, ErrDecryptedInPlace
+		}
 	}
-
-	if err := dnp.processFiles(tempDir); err != nil {
-		return false, fmt.Errorf("ошибка обработки файлов: %w", err)
+	var beforeCommit func() error
+	if inPlace && dnp.Backup != "" && dnp.Backup != BackupNone {
+		beforeCommit = func() error {
+			backup, err := createBackup(inputDocxPath, dnp.Backup)
+			dnp.BackupPath = backup
+			return err
+		}
 	}
 
-	if err := zipSource(tempDir, outputDocxPath); err != nil {
-		return false, fmt.Errorf("ошибка создания DOCX: %w", err)
+	err = writeFileAtomic(outputDocxPath, dnp.NoClobber && !inPlace, func(w io.Writer) error {
+		return dnp.ProcessStream(inputFile, info.Size(), w)
+	}, beforeCommit)
+	if err != nil {
+		return false, err
 	}
-
 	return true, nil
 }
 
-func (dnp *DocxNumberingProcessor) processFiles(tempDir string) error {
-	numberingPath := filepath.Join(tempDir, "word", "numbering.xml")
-	if _, err := os.Stat(numberingPath); err == nil {
-		content, err := os.ReadFile(numberingPath)
+func (dnp *DocxNumberingProcessor) ProcessStream(r io.ReaderAt, size int64, w io.Writer) error {
+	if dnp.limiter == nil {
+		dnp.limiter = newPackageLimiter(dnp.Limits)
+		defer func() { dnp.limiter = nil }()
+	}
+
+	inputFormat, err := detectInputFormat(r, size)
+	if err != nil {
+		return fmt.Errorf("ошибка определения формата файла: %w", err)
+	}
+
+	switch inputFormat {
+	case InputFormatCompound:
+		if err := dnp.processEncryptedPackage(r, size, w); err != nil {
+			return fmt.Errorf("ошибка обработки зашифрованного документа: %w", err)
+		}
+		return nil
+	case InputFormatWord2003:
+		content, err := dnp.readXMLInput(r, size)
 		if err != nil {
-			return fmt.Errorf("ошибка чтения numbering.xml: %w", err)
+			return err
 		}
-		if err := dnp.NumberingParser.ParseNumberingXML(content); err != nil {
-			return fmt.Errorf("ошибка парсинга numbering.xml: %w", err)
+		modified, err := dnp.processWord2003Document(content)
+		if err != nil {
+			return fmt.Errorf("ошибка обработки документа Word 2003 XML: %w", err)
 		}
+		_, err = w.Write(modified)
+		return err
 	}
 
-	documentPath := filepath.Join(tempDir, "word", "document.xml")
-	if _, err := os.Stat(documentPath); err == nil {
-		content, err := os.ReadFile(documentPath)
+	var pkg *Package
+	var flatPackage *etree.Document
+	if inputFormat == InputFormatFlatOPC {
+		content, err := dnp.readXMLInput(r, size)
 		if err != nil {
-			return fmt.Errorf("ошибка чтения document.xml: %w", err)
+			return err
 		}
+		if flatPackage, pkg, err = readFlatOPC(bytes.NewReader(content)); err != nil {
+			return fmt.Errorf("ошибка чтения Flat OPC: %w", err)
+		}
+		if err := dnp.limiter.checkEntries(len(pkg.PartNames())); err != nil {
+			return err
+		}
+	} else if pkg, err = OpenPackage(r, size, dnp.limiter); err != nil {
+		return fmt.Errorf("ошибка чтения DOCX: %w", err)
+	}
 
-		modifiedDocument, err := dnp.processDocument(content)
-		if err != nil {
-			return fmt.Errorf("ошибка обработки document.xml: %w", err)
+	dnp.sourceTime = pkg.LatestModified()
+
+	if err := dnp.applySignaturePolicy(pkg); err != nil {
+		return err
+	}
+	if err := dnp.checkProcessingStamp(pkg); err != nil {
+		return err
+	}
+	if err := dnp.processFiles(pkg); err != nil {
+		return fmt.Errorf("ошибка обработки файлов: %w", err)
+	}
+	if dnp.StampDocument {
+		if err := dnp.updateDocumentProperties(pkg); err != nil {
+			return fmt.Errorf("ошибка обновления свойств документа: %w", err)
 		}
+	}
 
-		if err := os.WriteFile(documentPath, modifiedDocument, 0644); err != nil {
-			return fmt.Errorf("ошибка записи document.xml: %w", err)
+	writeFlat := dnp.OutputFormat == OutputFormatFlatOPC || (dnp.OutputFormat == OutputFormatAuto && flatPackage != nil)
+	if writeFlat {
+		if err := writeFlatOPC(pkg, w, flatPackage); err != nil {
+			return fmt.Errorf("ошибка создания Flat OPC: %w", err)
 		}
+	} else if err := pkg.Save(w); err != nil {
+		return fmt.Errorf("ошибка создания DOCX: %w", err)
 	}
 	return nil
 }
 
-func (dnp *DocxNumberingProcessor) processDocument(documentContent []byte) ([]byte, error) {
-	doc := etree.NewDocument()
-	if err := doc.ReadFromBytes(documentContent); err != nil {
+func (dnp *DocxNumberingProcessor) readXMLInput(r io.ReaderAt, size int64) ([]byte, error) {
+	if err := dnp.limiter.addSize(size, ""); err != nil {
 		return nil, err
 	}
-	documentRoot := doc.Root()
-
-	CleanDocument(documentRoot)
-
-	paragraphFormatter := NewParagraphFormatter(dnp.NumberingParser.NumberingDefinitions)
-
-	for _, paragraph := range findAllElements(documentRoot, "//w:p") {
-		numPrefix := paragraphFormatter.FormatParagraph(paragraph)
-
-		if numPrefix != "" {
-			dnp.addNumberingToParagraph(paragraph, numPrefix)
-		}
-		dnp.removeNumPrTags(paragraph)
+	content, err := dnp.limiter.readXML(io.NewSectionReader(r, 0, size), "")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения файла: %w", err)
 	}
-	doc.Indent(2)
-	return doc.WriteToBytes()
+	return content, nil
 }
 
-func (dnp *DocxNumberingProcessor) addNumberingToParagraph(paragraph *etree.Element, numPrefix string) {
-
-	var firstT *etree.Element
-	if firstRElement := findElement(paragraph, ".//w:r"); firstRElement != nil {
-		firstT = findElement(firstRElement, ".//w:t")
+func (dnp *DocxNumberingProcessor) processFiles(pkg *Package) error {
+	parts, err := locatePackageParts(pkg)
+	if err != nil {
+		return fmt.Errorf("ошибка поиска частей документа: %w", err)
 	}
 
-	if firstT != nil {
-		currentText := firstT.Text()
-		space := " "
+	if parts.Numbering != "" && pkg.HasPart(parts.Numbering) {
+		content, err := pkg.ReadPart(parts.Numbering)
+		if err != nil {
+			return fmt.Errorf("ошибка чтения %s: %w", parts.Numbering, err)
+		}
+		if err := dnp.NumberingParser.ParseNumberingXML(content); err != nil {
+			return fmt.Errorf("ошибка парсинга %s: %w", parts.Numbering, err)
+		}
+	}
 
-		firstT.SetText(fmt.Sprintf("%s%s%s", numPrefix, space, currentText))
-	} else {
+	if parts.Styles != "" {
+		if content, err := pkg.ReadPart(parts.Styles); err == nil {
+			if err := dnp.StyleSheet.ParseStylesXML(content); err != nil {
+				return fmt.Errorf("ошибка парсинга %s: %w", parts.Styles, err)
+			}
+		}
+	}
 
-		rElement := createElement("r", nil)
-		tElement := createElement("t", nil)
+	var stream *documentStream
+	if pkg.HasPart(parts.MainDocument) && dnp.useStreaming() {
+		if stream, err = dnp.newDocumentStream(pkg, parts.MainDocument); err != nil {
+			return fmt.Errorf("ошибка обработки %s: %w", parts.MainDocument, err)
+		}
+		if err := pkg.TransformPart(parts.MainDocument, stream.process); err != nil {
+			return err
+		}
+	} else if pkg.HasPart(parts.MainDocument) {
+		content, err := pkg.ReadPart(parts.MainDocument)
+		if err != nil {
+			return fmt.Errorf("ошибка чтения %s: %w", parts.MainDocument, err)
+		}
 
-		tElement.CreateAttr("xml:space", "preserve")
-		tElement.SetText(numPrefix + " ")
+		modifiedDocument, err := dnp.processDocument(content)
+		if err != nil {
+			return fmt.Errorf("ошибка обработки %s: %w", parts.MainDocument, err)
+		}
+		pkg.WritePart(parts.MainDocument, modifiedDocument)
+	}
 
-		rElement.AddChild(tElement)
+	if err := dnp.processStories(pkg, parts); err != nil {
+		return err
+	}
+	if err := rewriteNumberedStyles(pkg, parts.Styles, dnp.NumberingParser.NumberingDefinitions, dnp.StyleSheet); err != nil {
+		return fmt.Errorf("ошибка обновления %s: %w", parts.Styles, err)
+	}
+	if err := ensureCharacterStyle(pkg, parts.Styles, dnp.NumberRunStyle); err != nil {
+		return fmt.Errorf("ошибка обновления %s: %w", parts.Styles, err)
+	}
 
-		existingChild := paragraph.ChildElements()
-		if len(existingChild) > 0 {
-			paragraph.InsertChild(existingChild[0], rElement)
-		} else {
-			paragraph.AddChild(rElement)
-		}
+	if err := dnp.processGlossaryDocument(pkg, parts.Glossaries); err != nil {
+		return fmt.Errorf("ошибка обработки глоссария: %w", err)
 	}
+	if err := dnp.processAltChunks(pkg, parts.MainDocument); err != nil {
+		return fmt.Errorf("ошибка обработки altChunk: %w", err)
+	}
+	if err := dnp.processEmbeddedPackages(pkg); err != nil {
+		return fmt.Errorf("ошибка обработки внедренных документов: %w", err)
+	}
+	if err := dnp.cleanupNumbering(pkg, parts, stream); err != nil {
+		return fmt.Errorf("ошибка очистки нумерации: %w", err)
+	}
+	return nil
 }
 
-func (dnp *DocxNumberingProcessor) removeNumPrTags(paragraph *etree.Element) {
+func (dnp *DocxNumberingProcessor) processDocument(documentContent []byte) ([]byte, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(documentContent); err != nil {
+		return nil, err
+	}
+	documentRoot := doc.Root()
+	snapshot := takeXMLSnapshot(doc)
 
-	for _, numPr := range findAllElements(paragraph, "./w:pPr/w:numPr") {
-		parent := numPr.Parent()
-		if parent != nil {
-			parent.RemoveChild(numPr)
-		}
+	ApplyTrackChanges(documentRoot, dnp.TrackChanges)
+	paragraphNumbers := dnp.numberParagraphs(documentRoot, dnp.NumberingParser.NumberingDefinitions, dnp.StyleSheet)
+	if dnp.FlattenFields {
+		NewFieldEngine(dnp.StyleSheet, paragraphNumbers).FlattenFields(documentRoot)
+	}
+	if dnp.ClauseBookmarks {
+		addClauseBookmarks(documentRoot, paragraphNumbers, dnp.BookmarkPrefix)
 	}
+	if dnp.RegenerateTOC {
+		regenerateTablesOfContents(documentRoot, dnp.StyleSheet, dnp.KeepTOCPages)
+	}
+
+	return snapshot.serialize(doc, documentContent)
 }
 
-func unzip(src, dest string) error {
-	r, err := zip.OpenReader(src)
+func (dnp *DocxNumberingProcessor) processStories(pkg *Package, parts PackageParts) error {
+	if len(parts.Stories) == 0 {
+		return nil
+	}
+	numberingContent, err := readOptionalPart(pkg, parts.Numbering)
 	if err != nil {
 		return err
 	}
-	defer r.Close()
-
-	for _, f := range r.File {
-		fpath := filepath.Join(dest, f.Name)
-		if !strings.HasPrefix(fpath, filepath.Clean(dest)+string(os.PathSeparator)) {
-			return fmt.Errorf("недопустимый путь к файлу: %s", fpath)
-		}
-
-		if f.FileInfo().IsDir() {
-			os.MkdirAll(fpath, os.ModePerm)
+	for _, partName := range parts.Stories {
+		content, err := pkg.ReadPart(partName)
+		if os.IsNotExist(err) {
 			continue
+		} else if err != nil {
+			return fmt.Errorf("ошибка чтения %s: %w", partName, err)
 		}
-
-		if err = os.MkdirAll(filepath.Dir(fpath), os.ModePerm); err != nil {
-			return err
-		}
-
-		outFile, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
+		modified, err := dnp.processStory(content, numberingContent)
 		if err != nil {
-			return err
-		}
-
-		rc, err := f.Open()
-		if err != nil {
-			outFile.Close()
-			return err
+			return fmt.Errorf("ошибка обработки %s: %w", partName, err)
 		}
+		pkg.WritePart(partName, modified)
+	}
+	return nil
+}
 
-		_, err = io.Copy(outFile, rc)
-		outFile.Close()
-		rc.Close()
+func (dnp *DocxNumberingProcessor) processStory(storyContent, numberingContent []byte) ([]byte, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(storyContent); err != nil {
+		return nil, err
+	}
+	storyRoot := doc.Root()
+	snapshot := takeXMLSnapshot(doc)
 
-		if err != nil {
-			return err
+	numberingParser := NewNumberingParser()
+	if numberingContent != nil {
+		if err := numberingParser.ParseNumberingXML(numberingContent); err != nil {
+			return nil, fmt.Errorf("ошибка парсинга нумерации: %w", err)
 		}
 	}
-	return nil
+	ApplyTrackChanges(storyRoot, dnp.TrackChanges)
+	dnp.numberParagraphs(storyRoot, numberingParser.NumberingDefinitions, dnp.StyleSheet)
+
+	return snapshot.serialize(doc, storyContent)
 }
 
-func zipSource(source, target string) error {
-	targetFile, err := os.Create(target)
-	if err != nil {
-		return err
-	}
-	defer targetFile.Close()
+type paragraphNumberer struct {
+	dnp       *DocxNumberingProcessor
+	formatter *ParagraphFormatter
+	recorder  *revisionRecorder
+	numbers   map[*etree.Element]*ParagraphNumber
+}
 
-	zipWriter := zip.NewWriter(targetFile)
-	defer zipWriter.Close()
+func (dnp *DocxNumberingProcessor) newParagraphNumberer(numberingDefinitions map[string]*NumberingDefinition, styleSheet *StyleSheet, recorder *revisionRecorder) *paragraphNumberer {
+	paragraphFormatter := NewParagraphFormatter(numberingDefinitions)
+	paragraphFormatter.StyleSheet = styleSheet
+	return &paragraphNumberer{
+		dnp:       dnp,
+		formatter: paragraphFormatter,
+		recorder:  recorder,
+		numbers:   make(map[*etree.Element]*ParagraphNumber),
+	}
+}
 
-	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if path == source {
-			return nil
-		}
+func (dnp *DocxNumberingProcessor) numberParagraphs(root *etree.Element, numberingDefinitions map[string]*NumberingDefinition, styleSheet *StyleSheet) map[*etree.Element]*ParagraphNumber {
+	numberer := dnp.newParagraphNumberer(numberingDefinitions, styleSheet, dnp.newRevisionRecorder(root))
+	for _, paragraph := range findAllElements(root, ".//w:p") {
+		numberer.numberParagraph(paragraph)
+	}
+	return numberer.numbers
+}
 
-		header, err := zip.FileInfoHeader(info)
-		if err != nil {
-			return err
+func (pn *paragraphNumberer) numberParagraph(paragraph *etree.Element) {
+	dnp, recorder := pn.dnp, pn.recorder
+	if isParagraphMarkDeleted(paragraph) {
+		dnp.removeNumPrTags(paragraph, nil, recorder)
+		return
+	}
+	level := pn.formatter.ParagraphLevel(paragraph)
+	numPrefix := pn.formatter.FormatParagraph(paragraph)
+
+	var numberRun *etree.Element
+	if numPrefix != "" {
+		if ilvl, numID, found := pn.formatter.NumberingInfo(paragraph); found && pn.numbers != nil {
+			if snapshot := pn.formatter.NumberSnapshot(ilvl, numID); snapshot != nil {
+				pn.numbers[paragraph] = snapshot
+			}
 		}
+		numberRun = dnp.addNumberingToParagraph(paragraph, numPrefix, level, pn.formatter.StyleSheet, recorder)
+	}
+	dnp.removeNumPrTags(paragraph, level, recorder)
+	if numberRun != nil {
+		alignNumberRun(paragraph, numberRun, level)
+	}
+}
 
-		relPath, err := filepath.Rel(source, path)
-		if err != nil {
-			return err
-		}
-		header.Name = filepath.ToSlash(relPath)
+func (dnp *DocxNumberingProcessor) addNumberingToParagraph(paragraph *etree.Element, numPrefix string, level *NumberingLevel, styleSheet *StyleSheet, recorder *revisionRecorder) *etree.Element {
+	numberRun := dnp.buildNumberRun(paragraph, numPrefix, level, styleSheet)
+	content := numberRun
+	if recorder != nil {
+		content = recorder.wrapInsertion(paragraph, numberRun)
+	}
 
-		if info.IsDir() {
-			header.Name += "/"
-		} else {
-			header.Method = zip.Deflate
-		}
+	if pPr := findElement(paragraph, "./w:pPr"); pPr != nil {
+		paragraph.InsertChildAt(pPr.Index()+1, content)
+	} else {
+		paragraph.InsertChildAt(0, content)
+	}
+	return numberRun
+}
 
-		writer, err := zipWriter.CreateHeader(header)
-		if err != nil {
-			return err
-		}
+func (dnp *DocxNumberingProcessor) removeNumPrTags(paragraph *etree.Element, level *NumberingLevel, recorder *revisionRecorder) {
+	numPrs := findAllElements(paragraph, "./w:pPr/w:numPr")
+	if len(numPrs) == 0 {
+		return
+	}
 
-		if info.IsDir() {
-			return nil
-		}
+	if recorder != nil {
+		recorder.recordPropertiesChange(numPrs[0].Parent())
+	}
+	applyLevelParagraphProperties(paragraph, level)
 
-		fileToZip, err := os.Open(path)
-		if err != nil {
-			return err
+	for _, numPr := range numPrs {
+		if parent := numPr.Parent(); parent != nil {
+			parent.RemoveChild(numPr)
 		}
-		defer fileToZip.Close()
-
-		_, err = io.Copy(writer, fileToZip)
-		return err
-	})
+	}
 }
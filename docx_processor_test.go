@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const testStoryStylesXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:style w:type="paragraph" w:styleId="HeaderList"><w:name w:val="Header List"/><w:pPr><w:numPr><w:numId w:val="2"/></w:numPr></w:pPr></w:style></w:styles>`
+
+func TestStoriesNumberedBeforeStylesRewrite(t *testing.T) {
+	parts := testNumberedParts(testNumberedDocument(3))
+	for i := range parts {
+		if parts[i].name == "word/_rels/document.xml.rels" {
+			parts[i].content = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/numbering" Target="numbering.xml"/><Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/><Relationship Id="rId3" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/header" Target="header1.xml"/><Relationship Id="rId4" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/footnotes" Target="footnotes.xml"/></Relationships>`
+		}
+	}
+	input := testPackage(t, append(parts,
+		testPart{"word/styles.xml", testStoryStylesXML},
+		testPart{"word/header1.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:hdr xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:p><w:pPr><w:pStyle w:val="HeaderList"/></w:pPr><w:r><w:t>Колонтитул</w:t></w:r></w:p></w:hdr>`},
+		testPart{"word/footnotes.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:footnotes xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:footnote w:id="1"><w:p><w:pPr><w:numPr><w:ilvl w:val="0"/><w:numId w:val="1"/></w:numPr></w:pPr><w:r><w:t>Сноска</w:t></w:r></w:p></w:footnote></w:footnotes>`},
+	))
+
+	processor := NewDocxNumberingProcessor()
+	var output bytes.Buffer
+	if err := processor.ProcessStream(bytes.NewReader(input), int64(len(input)), &output); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"word/header1.xml", "word/footnotes.xml"} {
+		content := string(readPackagePart(t, output.Bytes(), name))
+		if !strings.Contains(content, `<w:t xml:space="preserve">1.</w:t>`) || strings.Contains(content, "w:numPr") {
+			t.Errorf("%s не пронумерован: %s", name, content)
+		}
+	}
+	if styles := string(readPackagePart(t, output.Bytes(), "word/styles.xml")); strings.Contains(styles, "w:numPr") {
+		t.Errorf("нумерация не удалена из стилей: %s", styles)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/beevik/etree"
+)
+
+func (dnp *DocxNumberingProcessor) newNestedProcessor() *DocxNumberingProcessor {
+	nested := *dnp
+	nested.nestingDepth++
+	nested.NumberingParser = NewNumberingParser()
+	nested.StyleSheet = NewStyleSheet()
+	return &nested
+}
+
+func (dnp *DocxNumberingProcessor) processNestedFiles(nestedPkg *Package) error {
+	nested := dnp.newNestedProcessor()
+	if err := dnp.limiter.checkDepth(nested.nestingDepth); err != nil {
+		return err
+	}
+	if err := nested.processFiles(nestedPkg); err != nil {
+		return fmt.Errorf("ошибка обработки вложенного документа: %w", err)
+	}
+	return nil
+}
+
+func (dnp *DocxNumberingProcessor) processNestedPackage(pkg *Package, partName string, nested *Package) error {
+	if err := dnp.processNestedFiles(nested); err != nil {
+		return err
+	}
+	return writeNestedPackage(pkg, partName, nested)
+}
+
+func writeNestedPackage(pkg *Package, partName string, nested *Package) error {
+	var buf bytes.Buffer
+	if err := nested.Save(&buf); err != nil {
+		return fmt.Errorf("ошибка упаковки вложенного документа: %w", err)
+	}
+	pkg.WritePart(partName, buf.Bytes())
+	return nil
+}
+
+func (dnp *DocxNumberingProcessor) processEmbeddedPackages(pkg *Package) error {
+	embeddings, err := findRelatedParts(pkg, relTypePackage)
+	if err != nil {
+		return err
+	}
+	for _, embeddingPartName := range embeddings {
+		data, err := pkg.ReadPart(embeddingPartName)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		nested, err := openWordPackage(data, dnp.limiter)
+		if err != nil {
+			return fmt.Errorf("%s: %w", embeddingPartName, err)
+		} else if nested == nil {
+			continue
+		}
+		if err := dnp.processNestedPackage(pkg, embeddingPartName, nested); err != nil {
+			return fmt.Errorf("%s: %w", embeddingPartName, err)
+		}
+	}
+	return nil
+}
+
+func (dnp *DocxNumberingProcessor) processAltChunks(pkg *Package, mainDocumentPartName string) error {
+	rels, err := readPartRelationships(pkg, mainDocumentPartName)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения связей документа: %w", err)
+	}
+
+	chunkRels := rels.ByType(relTypeAltChunk)
+	if len(chunkRels) == 0 {
+		return nil
+	}
+
+	var inliner *altChunkInliner
+	if dnp.InlineAltChunks {
+		if inliner, err = newAltChunkInliner(pkg, mainDocumentPartName, rels); err != nil {
+			return err
+		}
+	}
+
+	for _, rel := range chunkRels {
+		if rel.TargetMode == targetModeExternal {
+			continue
+		}
+		partName := resolveRelationshipTarget(mainDocumentPartName, rel.Target)
+		if partName == "" {
+			continue
+		}
+		data, err := pkg.ReadPart(partName)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		chunkPkg, err := openWordPackage(data, dnp.limiter)
+		if err != nil {
+			return fmt.Errorf("altChunk %s: %w", rel.ID, err)
+		} else if chunkPkg == nil {
+			continue
+		}
+
+		if inliner == nil {
+			if err := dnp.processNestedPackage(pkg, partName, chunkPkg); err != nil {
+				return fmt.Errorf("altChunk %s: %w", rel.ID, err)
+			}
+			continue
+		}
+
+		if err := dnp.processNestedFiles(chunkPkg); err != nil {
+			return fmt.Errorf("altChunk %s: %w", rel.ID, err)
+		}
+		inlined, err := inliner.inline(rel.ID, chunkPkg)
+		if err == nil && !inlined {
+			err = writeNestedPackage(pkg, partName, chunkPkg)
+		}
+		if err != nil {
+			return fmt.Errorf("altChunk %s: %w", rel.ID, err)
+		}
+		if inlined {
+			rels.Remove(rel.ID)
+			pkg.RemovePart(partName)
+		}
+	}
+
+	if inliner == nil {
+		return nil
+	}
+	return inliner.finish()
+}
+
+func remapChunkRelationships(element *etree.Element, chunkPkg, pkg *Package, chunkDocumentPartName, mainDocumentPartName string, chunkRels *Relationships, chunkContentTypes *ContentTypes, rels *Relationships, contentTypes *ContentTypes, copiedIDs map[string]string) error {
+	for i := range element.Attr {
+		attr := &element.Attr[i]
+		if attr.Space == "" || !isNamespaceInPrefix(lookupNamespaceURI(element, attr.Space), relationshipsPrefix) {
+			continue
+		}
+		if newID, ok := copiedIDs[attr.Value]; ok {
+			attr.Value = newID
+			continue
+		}
+		rel, ok := chunkRels.ByID(attr.Value)
+		if !ok {
+			continue
+		}
+
+		if rel.TargetMode == targetModeExternal {
+			copiedIDs[attr.Value] = rels.Add(rel.Type, rel.Target, rel.TargetMode)
+			attr.Value = copiedIDs[attr.Value]
+			continue
+		}
+
+		sourcePartName := resolveRelationshipTarget(chunkDocumentPartName, rel.Target)
+		if sourcePartName == "" {
+			return fmt.Errorf("недопустимая ссылка %s в altChunk", rel.Target)
+		}
+		data, err := chunkPkg.ReadPart(sourcePartName)
+		if err != nil {
+			return fmt.Errorf("ошибка чтения части %s из altChunk: %w", sourcePartName, err)
+		}
+
+		targetPartName := uniqueChunkPartName(pkg, sourcePartName)
+		pkg.WritePart(targetPartName, data)
+
+		if contentType := chunkContentTypes.ContentType(sourcePartName); contentType != "" && contentTypes.ContentType(targetPartName) != contentType {
+			ext := path.Ext(targetPartName)
+			if ext != "" && contentTypes.defaultContentType(ext[1:]) == "" {
+				contentTypes.AddDefault(ext[1:], contentType)
+			} else {
+				contentTypes.SetOverride(targetPartName, contentType)
+			}
+		}
+
+		copiedIDs[attr.Value] = rels.Add(rel.Type, relativeRelationshipTarget(mainDocumentPartName, targetPartName), "")
+		attr.Value = copiedIDs[attr.Value]
+	}
+
+	for _, child := range element.ChildElements() {
+		if err := remapChunkRelationships(child, chunkPkg, pkg, chunkDocumentPartName, mainDocumentPartName, chunkRels, chunkContentTypes, rels, contentTypes, copiedIDs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func uniqueChunkPartName(pkg *Package, sourcePartName string) string {
+	for n := 1; ; n++ {
+		partName := path.Join(path.Dir(sourcePartName), fmt.Sprintf("chunk%d_%s", n, path.Base(sourcePartName)))
+		if !pkg.HasPart(partName) {
+			return partName
+		}
+	}
+}
+
+func openWordPackage(data []byte, limiter *packageLimiter) (*Package, error) {
+	pkg, err := OpenPackage(bytes.NewReader(data), int64(len(data)), limiter)
+	if errors.Is(err, zip.ErrFormat) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	content, err := pkg.ReadPart(contentTypesPartName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	contentTypes, err := ParseContentTypes(content)
+	if err != nil || contentTypes.PartNameForContentType(wordMainContentTypes) == "" {
+		return nil, nil
+	}
+	return pkg, nil
+}
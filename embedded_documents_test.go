@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func testPackageWithEmbedding(t *testing.T, embedding []byte) []byte {
+	parts := testNumberedParts(testNumberedDocument(1))
+	for i := range parts {
+		if parts[i].name == "word/_rels/document.xml.rels" {
+			parts[i].content = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/numbering" Target="numbering.xml"/><Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/package" Target="embeddings/nested.docx"/></Relationships>`
+		}
+	}
+	return testPackage(t, append(parts, testPart{"word/embeddings/nested.docx", string(embedding)}))
+}
+
+func TestEmbeddedPackageLimitExceeded(t *testing.T) {
+	input := testPackageWithEmbedding(t, testNumberedPackage(t, testNumberedDocument(500)))
+
+	processor := NewDocxNumberingProcessor()
+	processor.Limits.MaxXMLElements = 1000
+	err := processor.ProcessStream(bytes.NewReader(input), int64(len(input)), io.Discard)
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("ожидалась ошибка ErrLimitExceeded, получено %v", err)
+	}
+}
+
+func TestEmbeddedNonPackageSkipped(t *testing.T) {
+	input := testPackageWithEmbedding(t, []byte("не архив"))
+
+	processor := NewDocxNumberingProcessor()
+	if err := processor.ProcessStream(bytes.NewReader(input), int64(len(input)), io.Discard); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInlineAltChunkRemapsNotesAndBookmarks(t *testing.T) {
+	const wordNS = `xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"`
+	const footnotesType = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/footnotes"
+	const footnotesContentType = "application/vnd.openxmlformats-officedocument.wordprocessingml.footnotes+xml"
+	footnotes := func(text string) string {
+		return `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:footnotes ` + wordNS + `><w:footnote w:type="separator" w:id="-1"><w:p><w:r><w:separator/></w:r></w:p></w:footnote><w:footnote w:type="continuationSeparator" w:id="0"><w:p><w:r><w:continuationSeparator/></w:r></w:p></w:footnote><w:footnote w:id="1"><w:p><w:r><w:t>` + text + `</w:t></w:r></w:p></w:footnote></w:footnotes>`
+	}
+	contentTypes := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Default Extension="docx" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document"/><Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/><Override PartName="/word/footnotes.xml" ContentType="` + footnotesContentType + `"/></Types>`
+	packageRels := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/></Relationships>`
+
+	chunk := testPackage(t, []testPart{
+		{contentTypesPartName, contentTypes},
+		{"_rels/.rels", packageRels},
+		{"word/document.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:document ` + wordNS + `><w:body><w:p><w:bookmarkStart w:id="0" w:name="Общая"/><w:r><w:t>Вставка</w:t></w:r><w:bookmarkEnd w:id="0"/><w:r><w:footnoteReference w:id="1"/></w:r></w:p><w:p><w:r><w:instrText xml:space="preserve"> REF Общая \h </w:instrText></w:r></w:p><w:sectPr/></w:body></w:document>`},
+		{"word/_rels/document.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="` + footnotesType + `" Target="footnotes.xml"/></Relationships>`},
+		{"word/footnotes.xml", footnotes("Сноска вставки")},
+	})
+	hostDocument := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:document ` + wordNS + ` xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><w:body>
+  <w:p><w:bookmarkStart w:id="0" w:name="Общая"/><w:r><w:t>Основной текст</w:t></w:r><w:bookmarkEnd w:id="0"/><w:r><w:footnoteReference w:id="1"/></w:r></w:p>
+  <w:altChunk r:id="rId5"/>
+  <w:sectPr   w:rsidR="00AB"/></w:body></w:document>`
+	input := testPackage(t, []testPart{
+		{contentTypesPartName, contentTypes},
+		{"_rels/.rels", packageRels},
+		{"word/document.xml", hostDocument},
+		{"word/_rels/document.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="` + footnotesType + `" Target="footnotes.xml"/><Relationship Id="rId5" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/aFChunk" Target="chunk.docx"/></Relationships>`},
+		{"word/footnotes.xml", footnotes("Сноска основного текста")},
+		{"word/chunk.docx", string(chunk)},
+	})
+
+	processor := NewDocxNumberingProcessor()
+	processor.InlineAltChunks = true
+	processor.StampDocument = false
+	var output bytes.Buffer
+	if err := processor.ProcessStream(bytes.NewReader(input), int64(len(input)), &output); err != nil {
+		t.Fatal(err)
+	}
+
+	document := string(readPackagePart(t, output.Bytes(), "word/document.xml"))
+	for _, want := range []string{
+		`<w:sectPr   w:rsidR="00AB"/>`,
+		`<w:bookmarkStart w:id="1" w:name="Общая__2"/>`,
+		`<w:bookmarkEnd w:id="1"/>`,
+		`<w:footnoteReference w:id="2"/>`,
+		` REF Общая__2 \h `,
+	} {
+		if !strings.Contains(document, want) {
+			t.Errorf("в документе нет %s: %s", want, document)
+		}
+	}
+	if strings.Contains(document, "altChunk") {
+		t.Errorf("altChunk не встроен: %s", document)
+	}
+	footnotesPart := string(readPackagePart(t, output.Bytes(), "word/footnotes.xml"))
+	if !strings.Contains(footnotesPart, `<w:footnote w:id="2"><w:p><w:r><w:t>Сноска вставки</w:t>`) || !strings.Contains(footnotesPart, "Сноска основного текста") {
+		t.Errorf("сноска altChunk не перенесена: %s", footnotesPart)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+func (dnp *DocxNumberingProcessor) processEncryptedPackage(r io.ReaderAt, size int64, w io.Writer) error {
+	data, err := io.ReadAll(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return fmt.Errorf("ошибка чтения файла: %w", err)
+	}
+	compound, err := ReadCompoundFile(data)
+	if err != nil {
+		return err
+	}
+	encryptionInfoData, hasInfo := compound.Stream(encryptionInfoStreamName)
+	encryptedPackage, hasPackage := compound.Stream(encryptedPackageStreamName)
+	if !hasInfo || !hasPackage {
+		return fmt.Errorf("составной документ OLE не содержит зашифрованного пакета (возможно, это документ Word 97-2003 .doc)")
+	}
+	if dnp.ReEncrypt && dnp.OutputFormat == OutputFormatFlatOPC {
+		return fmt.Errorf("зашифровать можно только упакованный DOCX, а не Flat OPC")
+	}
+
+	info, err := ParseAgileEncryptionInfo(encryptionInfoData)
+	if err != nil {
+		return err
+	}
+	if dnp.Password == "" {
+		return ErrPasswordRequired
+	}
+	key, err := info.DecryptKey(dnp.Password)
+	if err != nil {
+		return err
+	}
+	if valid, err := info.VerifyIntegrity(key, encryptedPackage); err != nil {
+		return fmt.Errorf("ошибка проверки целостности: %w", err)
+	} else if !valid {
+		return ErrIntegrity
+	}
+	packageData, err := info.DecryptPackage(key, encryptedPackage)
+	if err != nil {
+		return fmt.Errorf("ошибка расшифровки пакета: %w", err)
+	}
+
+	if err := dnp.limiter.addSize(int64(len(packageData)), encryptedPackageStreamName); err != nil {
+		return err
+	}
+	if err := dnp.limiter.checkDepth(dnp.nestingDepth + 1); err != nil {
+		return err
+	}
+	dnp.nestingDepth++
+	defer func() { dnp.nestingDepth-- }()
+
+	var output bytes.Buffer
+	if err := dnp.ProcessStream(bytes.NewReader(packageData), int64(len(packageData)), &output); err != nil {
+		return err
+	}
+	result := output.Bytes()
+
+	if dnp.ReEncrypt {
+		if result, err = encryptPackage(compound, info, dnp.Password, result); err != nil {
+			return fmt.Errorf("ошибка шифрования результата: %w", err)
+		}
+	}
+	_, err = w.Write(result)
+	return err
+}
+
+func encryptPackage(original *CompoundEntry, template *AgileEncryptionInfo, password string, packageData []byte) ([]byte, error) {
+	info, key, err := NewAgileEncryption(template, password)
+	if err != nil {
+		return nil, err
+	}
+	encryptedPackage, err := info.EncryptPackage(key, packageData)
+	if err != nil {
+		return nil, err
+	}
+	encryptionInfo, err := info.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	root := &CompoundEntry{Name: original.Name, Storage: true, CLSID: original.CLSID}
+	for _, child := range original.Children {
+		switch {
+		case child.Name == encryptionInfoStreamName:
+			root.Children = append(root.Children, &CompoundEntry{Name: child.Name, Data: encryptionInfo})
+		case child.Name == encryptedPackageStreamName:
+			root.Children = append(root.Children, &CompoundEntry{Name: child.Name, Data: encryptedPackage})
+		default:
+			root.Children = append(root.Children, child)
+		}
+	}
+	if root.Child(dataSpacesStorageName) == nil {
+		return nil, fmt.Errorf("в исходном файле отсутствует хранилище %q", dataSpacesStorageName)
+	}
+	return WriteCompoundFile(root), nil
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"unicode/utf16"
+
+	"github.com/beevik/etree"
+)
+
+const (
+	encryptionInfoStreamName   = "EncryptionInfo"
+	encryptedPackageStreamName = "EncryptedPackage"
+	dataSpacesStorageName      = "\x06DataSpaces"
+
+	encryptionNS              = "http://schemas.microsoft.com/office/2006/encryption"
+	passwordKeyEncryptorNS    = "http://schemas.microsoft.com/office/2006/keyEncryptor/password"
+	certificateKeyEncryptorNS = "http://schemas.microsoft.com/office/2006/keyEncryptor/certificate"
+
+	agileEncryptionVersion  = 4
+	agileEncryptionFlags    = 0x40
+	encryptedSegmentSize    = 4096
+	encryptedPackageSizeLen = 8
+	keyDerivationPadding    = 0x36
+	maxSpinCount            = 10000000
+)
+
+var (
+	blockKeyVerifierHashInput = []byte{0xfe, 0xa7, 0xd2, 0x76, 0x3b, 0x4b, 0x9e, 0x79}
+	blockKeyVerifierHashValue = []byte{0xd7, 0xaa, 0x0f, 0x6d, 0x30, 0x61, 0x34, 0x4e}
+	blockKeyEncryptedKeyValue = []byte{0x14, 0x6e, 0x0b, 0xe7, 0xab, 0xac, 0xd0, 0xd6}
+	blockKeyIntegrityKey      = []byte{0x5f, 0xb2, 0xad, 0x01, 0x0c, 0xb9, 0xe1, 0xf6}
+	blockKeyIntegrityValue    = []byte{0xa0, 0x67, 0x7f, 0x02, 0xb2, 0x2c, 0x84, 0x33}
+)
+
+var (
+	ErrPasswordRequired = errors.New("документ защищен паролем, требуется пароль")
+	ErrWrongPassword    = errors.New("неверный пароль")
+	ErrIntegrity        = errors.New("контрольная сумма HMAC зашифрованного пакета не совпадает, файл поврежден или изменен")
+)
+
+type cipherParameters struct {
+	SaltValue       []byte
+	SaltSize        int
+	BlockSize       int
+	KeyBits         int
+	HashSize        int
+	CipherAlgorithm string
+	CipherChaining  string
+	HashAlgorithm   string
+}
+
+type AgileEncryptionInfo struct {
+	KeyData                    cipherParameters
+	PasswordKey                cipherParameters
+	SpinCount                  int
+	EncryptedHmacKey           []byte
+	EncryptedHmacValue         []byte
+	EncryptedVerifierHashInput []byte
+	EncryptedVerifierHashValue []byte
+	EncryptedKeyValue          []byte
+}
+
+func ParseAgileEncryptionInfo(data []byte) (*AgileEncryptionInfo, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("поток EncryptionInfo слишком короткий")
+	}
+	major, minor := binary.LittleEndian.Uint16(data[0:]), binary.LittleEndian.Uint16(data[2:])
+	if major != agileEncryptionVersion || minor != agileEncryptionVersion {
+		return nil, fmt.Errorf("неподдерживаемый тип шифрования (версия %d.%d), поддерживается только Agile Encryption 4.4", major, minor)
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data[8:]); err != nil {
+		return nil, fmt.Errorf("ошибка парсинга описания шифрования: %w", err)
+	}
+	root := doc.Root()
+	if root == nil || root.Tag != "encryption" || root.NamespaceURI() != encryptionNS {
+		return nil, fmt.Errorf("некорректное описание шифрования")
+	}
+
+	info := &AgileEncryptionInfo{}
+	var err error
+	keyData := encryptionChild(root, encryptionNS, "keyData")
+	if keyData == nil {
+		return nil, fmt.Errorf("в описании шифрования отсутствует keyData")
+	}
+	if info.KeyData, err = parseCipherParameters(keyData); err != nil {
+		return nil, err
+	}
+	if dataIntegrity := encryptionChild(root, encryptionNS, "dataIntegrity"); dataIntegrity != nil {
+		info.EncryptedHmacKey, _ = base64.StdEncoding.DecodeString(dataIntegrity.SelectAttrValue("encryptedHmacKey", ""))
+		info.EncryptedHmacValue, _ = base64.StdEncoding.DecodeString(dataIntegrity.SelectAttrValue("encryptedHmacValue", ""))
+	}
+
+	var encryptedKey *etree.Element
+	if keyEncryptors := encryptionChild(root, encryptionNS, "keyEncryptors"); keyEncryptors != nil {
+		for _, keyEncryptor := range keyEncryptors.ChildElements() {
+			if keyEncryptor.SelectAttrValue("uri", "") == passwordKeyEncryptorNS {
+				encryptedKey = encryptionChild(keyEncryptor, passwordKeyEncryptorNS, "encryptedKey")
+				break
+			}
+		}
+	}
+	if encryptedKey == nil {
+		return nil, fmt.Errorf("документ зашифрован без пароля (например, сертификатом), такой тип шифрования не поддерживается")
+	}
+	if info.PasswordKey, err = parseCipherParameters(encryptedKey); err != nil {
+		return nil, err
+	}
+	if info.SpinCount, err = strconv.Atoi(encryptedKey.SelectAttrValue("spinCount", "")); err != nil || info.SpinCount < 0 || info.SpinCount > maxSpinCount {
+		return nil, fmt.Errorf("некорректное значение spinCount")
+	}
+	for attr, target := range map[string]*[]byte{
+		"encryptedVerifierHashInput": &info.EncryptedVerifierHashInput,
+		"encryptedVerifierHashValue": &info.EncryptedVerifierHashValue,
+		"encryptedKeyValue":          &info.EncryptedKeyValue,
+	} {
+		if *target, err = base64.StdEncoding.DecodeString(encryptedKey.SelectAttrValue(attr, "")); err != nil {
+			return nil, fmt.Errorf("некорректное значение %s: %w", attr, err)
+		}
+	}
+	return info, nil
+}
+
+func encryptionChild(parent *etree.Element, namespace, tag string) *etree.Element {
+	for _, child := range parent.ChildElements() {
+		if child.Tag == tag && child.NamespaceURI() == namespace {
+			return child
+		}
+	}
+	return nil
+}
+
+func parseCipherParameters(element *etree.Element) (cipherParameters, error) {
+	params := cipherParameters{
+		CipherAlgorithm: element.SelectAttrValue("cipherAlgorithm", ""),
+		CipherChaining:  element.SelectAttrValue("cipherChaining", ""),
+		HashAlgorithm:   element.SelectAttrValue("hashAlgorithm", ""),
+	}
+	for attr, target := range map[string]*int{
+		"saltSize":  &params.SaltSize,
+		"blockSize": &params.BlockSize,
+		"keyBits":   &params.KeyBits,
+		"hashSize":  &params.HashSize,
+	} {
+		value, err := strconv.Atoi(element.SelectAttrValue(attr, ""))
+		if err != nil || value <= 0 {
+			return params, fmt.Errorf("некорректное значение %s в описании шифрования", attr)
+		}
+		*target = value
+	}
+	salt, err := base64.StdEncoding.DecodeString(element.SelectAttrValue("saltValue", ""))
+	if err != nil {
+		return params, fmt.Errorf("некорректное значение saltValue: %w", err)
+	}
+	params.SaltValue = salt
+
+	if params.CipherAlgorithm != "AES" || params.CipherChaining != "ChainingModeCBC" {
+		return params, fmt.Errorf("неподдерживаемый алгоритм шифрования %s/%s", params.CipherAlgorithm, params.CipherChaining)
+	}
+	if params.KeyBits != 128 && params.KeyBits != 192 && params.KeyBits != 256 {
+		return params, fmt.Errorf("неподдерживаемая длина ключа AES: %d", params.KeyBits)
+	}
+	if params.BlockSize != aes.BlockSize {
+		return params, fmt.Errorf("неподдерживаемый размер блока: %d", params.BlockSize)
+	}
+	newHash := hashFunction(params.HashAlgorithm)
+	if newHash == nil {
+		return params, fmt.Errorf("неподдерживаемый алгоритм хеширования %s", params.HashAlgorithm)
+	}
+	if params.HashSize != newHash().Size() {
+		return params, fmt.Errorf("размер хеша %d не соответствует алгоритму %s", params.HashSize, params.HashAlgorithm)
+	}
+	return params, nil
+}
+
+func hashFunction(name string) func() hash.Hash {
+	switch name {
+	case "SHA1":
+		return sha1.New
+	case "SHA256":
+		return sha256.New
+	case "SHA384":
+		return sha512.New384
+	case "SHA512":
+		return sha512.New
+	}
+	return nil
+}
+
+func (cp cipherParameters) hash(parts ...[]byte) []byte {
+	h := hashFunction(cp.HashAlgorithm)()
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
+
+func fitLength(data []byte, length int) []byte {
+	if len(data) >= length {
+		return data[:length]
+	}
+	return append(append([]byte(nil), data...), bytes.Repeat([]byte{keyDerivationPadding}, length-len(data))...)
+}
+
+func (info *AgileEncryptionInfo) passwordHash(password string) []byte {
+	passwordBytes := make([]byte, 0, 2*len(password))
+	for _, unit := range utf16.Encode([]rune(password)) {
+		passwordBytes = binary.LittleEndian.AppendUint16(passwordBytes, unit)
+	}
+
+	params := info.PasswordKey
+	h := hashFunction(params.HashAlgorithm)()
+	h.Write(params.SaltValue)
+	h.Write(passwordBytes)
+	digest := h.Sum(nil)
+	iterator := make([]byte, 4)
+	for i := 0; i < info.SpinCount; i++ {
+		binary.LittleEndian.PutUint32(iterator, uint32(i))
+		h.Reset()
+		h.Write(iterator)
+		h.Write(digest)
+		digest = h.Sum(digest[:0])
+	}
+	return digest
+}
+
+func (info *AgileEncryptionInfo) passwordKey(passwordHash, blockKey []byte) []byte {
+	return fitLength(info.PasswordKey.hash(passwordHash, blockKey), info.PasswordKey.KeyBits/8)
+}
+
+func cbcCrypt(key, iv, data []byte, encrypt bool) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%block.BlockSize() != 0 {
+		if !encrypt {
+			return nil, fmt.Errorf("длина зашифрованных данных не кратна размеру блока")
+		}
+		data = append(append([]byte(nil), data...), make([]byte, block.BlockSize()-len(data)%block.BlockSize())...)
+	}
+	result := make([]byte, len(data))
+	if encrypt {
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(result, data)
+	} else {
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(result, data)
+	}
+	return result, nil
+}
+
+func (info *AgileEncryptionInfo) DecryptKey(password string) ([]byte, error) {
+	passwordHash := info.passwordHash(password)
+	iv := fitLength(info.PasswordKey.SaltValue, info.PasswordKey.BlockSize)
+
+	verifierInput, err := cbcCrypt(info.passwordKey(passwordHash, blockKeyVerifierHashInput), iv, info.EncryptedVerifierHashInput, false)
+	if err != nil {
+		return nil, err
+	}
+	verifierHash, err := cbcCrypt(info.passwordKey(passwordHash, blockKeyVerifierHashValue), iv, info.EncryptedVerifierHashValue, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(verifierInput) < info.PasswordKey.SaltSize || len(verifierHash) < info.PasswordKey.HashSize {
+		return nil, fmt.Errorf("некорректные данные проверки пароля")
+	}
+	expected := info.PasswordKey.hash(verifierInput[:info.PasswordKey.SaltSize])
+	if !hmac.Equal(expected, verifierHash[:info.PasswordKey.HashSize]) {
+		return nil, ErrWrongPassword
+	}
+
+	key, err := cbcCrypt(info.passwordKey(passwordHash, blockKeyEncryptedKeyValue), iv, info.EncryptedKeyValue, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) < info.KeyData.KeyBits/8 {
+		return nil, fmt.Errorf("некорректная длина ключа шифрования")
+	}
+	return key[:info.KeyData.KeyBits/8], nil
+}
+
+func (info *AgileEncryptionInfo) segmentIV(index uint32) []byte {
+	blockKey := binary.LittleEndian.AppendUint32(nil, index)
+	return fitLength(info.KeyData.hash(info.KeyData.SaltValue, blockKey), info.KeyData.BlockSize)
+}
+
+func (info *AgileEncryptionInfo) integrityIV(blockKey []byte) []byte {
+	return fitLength(info.KeyData.hash(info.KeyData.SaltValue, blockKey), info.KeyData.BlockSize)
+}
+
+func (info *AgileEncryptionInfo) DecryptPackage(key, encryptedPackage []byte) ([]byte, error) {
+	if len(encryptedPackage) < encryptedPackageSizeLen {
+		return nil, fmt.Errorf("поток EncryptedPackage слишком короткий")
+	}
+	size := binary.LittleEndian.Uint64(encryptedPackage)
+	encrypted := encryptedPackage[encryptedPackageSizeLen:]
+	if size > uint64(len(encrypted)) {
+		return nil, fmt.Errorf("размер пакета %d превышает длину зашифрованных данных", size)
+	}
+
+	result := make([]byte, 0, len(encrypted))
+	for index := uint32(0); len(encrypted) > 0; index++ {
+		segment := encrypted[:min(len(encrypted), encryptedSegmentSize)]
+		encrypted = encrypted[len(segment):]
+		if remainder := len(segment) % info.KeyData.BlockSize; remainder != 0 {
+			segment = segment[:len(segment)-remainder]
+		}
+		plain, err := cbcCrypt(key, info.segmentIV(index), segment, false)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, plain...)
+	}
+	if size > uint64(len(result)) {
+		return nil, fmt.Errorf("поврежденный зашифрованный пакет")
+	}
+	return result[:size], nil
+}
+
+func (info *AgileEncryptionInfo) VerifyIntegrity(key, encryptedPackage []byte) (bool, error) {
+	if len(info.EncryptedHmacKey) == 0 || len(info.EncryptedHmacValue) == 0 {
+		return true, nil
+	}
+	hmacKey, err := cbcCrypt(key, info.integrityIV(blockKeyIntegrityKey), info.EncryptedHmacKey, false)
+	if err != nil {
+		return false, err
+	}
+	hmacValue, err := cbcCrypt(key, info.integrityIV(blockKeyIntegrityValue), info.EncryptedHmacValue, false)
+	if err != nil {
+		return false, err
+	}
+	hashSize := info.KeyData.HashSize
+	if len(hmacKey) < hashSize || len(hmacValue) < hashSize {
+		return false, nil
+	}
+	mac := hmac.New(hashFunction(info.KeyData.HashAlgorithm), hmacKey[:hashSize])
+	mac.Write(encryptedPackage)
+	return hmac.Equal(mac.Sum(nil), hmacValue[:hashSize]), nil
+}
+
+func randomBytes(length int) ([]byte, error) {
+	data := make([]byte, length)
+	if _, err := rand.Read(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func NewAgileEncryption(template *AgileEncryptionInfo, password string) (*AgileEncryptionInfo, []byte, error) {
+	info := &AgileEncryptionInfo{
+		KeyData:     template.KeyData,
+		PasswordKey: template.PasswordKey,
+		SpinCount:   template.SpinCount,
+	}
+	var err error
+	if info.KeyData.SaltValue, err = randomBytes(info.KeyData.SaltSize); err != nil {
+		return nil, nil, err
+	}
+	if info.PasswordKey.SaltValue, err = randomBytes(info.PasswordKey.SaltSize); err != nil {
+		return nil, nil, err
+	}
+	key, err := randomBytes(info.KeyData.KeyBits / 8)
+	if err != nil {
+		return nil, nil, err
+	}
+	verifierInput, err := randomBytes(info.PasswordKey.SaltSize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	passwordHash := info.passwordHash(password)
+	iv := fitLength(info.PasswordKey.SaltValue, info.PasswordKey.BlockSize)
+	if info.EncryptedVerifierHashInput, err = cbcCrypt(info.passwordKey(passwordHash, blockKeyVerifierHashInput), iv, verifierInput, true); err != nil {
+		return nil, nil, err
+	}
+	if info.EncryptedVerifierHashValue, err = cbcCrypt(info.passwordKey(passwordHash, blockKeyVerifierHashValue), iv, info.PasswordKey.hash(verifierInput), true); err != nil {
+		return nil, nil, err
+	}
+	if info.EncryptedKeyValue, err = cbcCrypt(info.passwordKey(passwordHash, blockKeyEncryptedKeyValue), iv, key, true); err != nil {
+		return nil, nil, err
+	}
+	return info, key, nil
+}
+
+func (info *AgileEncryptionInfo) EncryptPackage(key, packageData []byte) ([]byte, error) {
+	result := binary.LittleEndian.AppendUint64(nil, uint64(len(packageData)))
+	for index := uint32(0); len(packageData) > 0; index++ {
+		segment := packageData[:min(len(packageData), encryptedSegmentSize)]
+		packageData = packageData[len(segment):]
+		encrypted, err := cbcCrypt(key, info.segmentIV(index), segment, true)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, encrypted...)
+	}
+
+	hmacKey, err := randomBytes(info.KeyData.HashSize)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(hashFunction(info.KeyData.HashAlgorithm), hmacKey)
+	mac.Write(result)
+	if info.EncryptedHmacKey, err = cbcCrypt(key, info.integrityIV(blockKeyIntegrityKey), hmacKey, true); err != nil {
+		return nil, err
+	}
+	if info.EncryptedHmacValue, err = cbcCrypt(key, info.integrityIV(blockKeyIntegrityValue), mac.Sum(nil), true); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (info *AgileEncryptionInfo) Bytes() ([]byte, error) {
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="UTF-8" standalone="yes"`)
+	root := doc.CreateElement("encryption")
+	root.CreateAttr("xmlns", encryptionNS)
+	root.CreateAttr("xmlns:p", passwordKeyEncryptorNS)
+	root.CreateAttr("xmlns:c", certificateKeyEncryptorNS)
+
+	info.KeyData.writeAttributes(root.CreateElement("keyData"))
+	dataIntegrity := root.CreateElement("dataIntegrity")
+	dataIntegrity.CreateAttr("encryptedHmacKey", base64.StdEncoding.EncodeToString(info.EncryptedHmacKey))
+	dataIntegrity.CreateAttr("encryptedHmacValue", base64.StdEncoding.EncodeToString(info.EncryptedHmacValue))
+
+	keyEncryptor := root.CreateElement("keyEncryptors").CreateElement("keyEncryptor")
+	keyEncryptor.CreateAttr("uri", passwordKeyEncryptorNS)
+	encryptedKey := keyEncryptor.CreateElement("p:encryptedKey")
+	encryptedKey.CreateAttr("spinCount", strconv.Itoa(info.SpinCount))
+	info.PasswordKey.writeAttributes(encryptedKey)
+	encryptedKey.CreateAttr("encryptedVerifierHashInput", base64.StdEncoding.EncodeToString(info.EncryptedVerifierHashInput))
+	encryptedKey.CreateAttr("encryptedVerifierHashValue", base64.StdEncoding.EncodeToString(info.EncryptedVerifierHashValue))
+	encryptedKey.CreateAttr("encryptedKeyValue", base64.StdEncoding.EncodeToString(info.EncryptedKeyValue))
+
+	content, err := doc.WriteToBytes()
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 8)
+	binary.LittleEndian.PutUint16(header[0:], agileEncryptionVersion)
+	binary.LittleEndian.PutUint16(header[2:], agileEncryptionVersion)
+	binary.LittleEndian.PutUint32(header[4:], agileEncryptionFlags)
+	return append(header, content...), nil
+}
+
+func (cp cipherParameters) writeAttributes(element *etree.Element) {
+	element.CreateAttr("saltSize", strconv.Itoa(cp.SaltSize))
+	element.CreateAttr("blockSize", strconv.Itoa(cp.BlockSize))
+	element.CreateAttr("keyBits", strconv.Itoa(cp.KeyBits))
+	element.CreateAttr("hashSize", strconv.Itoa(cp.HashSize))
+	element.CreateAttr("cipherAlgorithm", cp.CipherAlgorithm)
+	element.CreateAttr("cipherChaining", cp.CipherChaining)
+	element.CreateAttr("hashAlgorithm", cp.HashAlgorithm)
+	element.CreateAttr("saltValue", base64.StdEncoding.EncodeToString(cp.SaltValue))
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testCipherParameters() cipherParameters {
+	return cipherParameters{SaltSize: 16, BlockSize: 16, KeyBits: 256, HashSize: 64, CipherAlgorithm: "AES", CipherChaining: "ChainingModeCBC", HashAlgorithm: "SHA512"}
+}
+
+func testEncryptionTemplate(spinCount int) *AgileEncryptionInfo {
+	return &AgileEncryptionInfo{KeyData: testCipherParameters(), PasswordKey: testCipherParameters(), SpinCount: spinCount}
+}
+
+func testEncryptedCompound() *CompoundEntry {
+	dataSpaces := &CompoundEntry{Name: dataSpacesStorageName, Storage: true, Children: []*CompoundEntry{
+		{Name: "Version", Data: make([]byte, 76)},
+		{Name: "DataSpaceMap", Data: make([]byte, 112)},
+		{Name: "DataSpaceInfo", Storage: true, Children: []*CompoundEntry{{Name: "StrongEncryptionDataSpace", Data: make([]byte, 64)}}},
+		{Name: "TransformInfo", Storage: true, Children: []*CompoundEntry{{Name: "StrongEncryptionTransform", Storage: true, Children: []*CompoundEntry{{Name: "\x06Primary", Data: make([]byte, 200)}}}}},
+	}}
+	return &CompoundEntry{Name: "Root Entry", Storage: true, Children: []*CompoundEntry{dataSpaces, {Name: encryptionInfoStreamName}, {Name: encryptedPackageStreamName}}}
+}
+
+func sequenceBytes(start, length int) []byte {
+	data := make([]byte, length)
+	for i := range data {
+		data[i] = byte(start + i)
+	}
+	return data
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func readEncryptedCompound(t *testing.T, data []byte) (*AgileEncryptionInfo, []byte) {
+	t.Helper()
+	compound, err := ReadCompoundFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infoData, hasInfo := compound.Stream(encryptionInfoStreamName)
+	encryptedPackage, hasPackage := compound.Stream(encryptedPackageStreamName)
+	if !hasInfo || !hasPackage {
+		t.Fatal("в составном файле нет потоков шифрования")
+	}
+	info, err := ParseAgileEncryptionInfo(infoData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info, encryptedPackage
+}
+
+func TestAgileEncryptionKnownAnswer(t *testing.T) {
+	// Эталонные значения рассчитаны независимо (hashlib и openssl) по MS-OFFCRYPTO 2.3.4.
+	const password = "Password1234_"
+	info := testEncryptionTemplate(100000)
+	info.PasswordKey.SaltValue = sequenceBytes(0, 16)
+	info.KeyData.SaltValue = sequenceBytes(16, 16)
+	info.EncryptedVerifierHashInput = mustDecodeHex(t, "27fc5580ceb745ec1d9017ab3ed3edf0")
+	info.EncryptedVerifierHashValue = mustDecodeHex(t, "cbfe2ee75cc17573e0536011420f504c9a8ff9d2d2780a9df5111eca26ee20e0f9bcd580d6e84b08a4141648e302bf7f57c4e9978bda520772b7c6d4151adbfc")
+	info.EncryptedKeyValue = mustDecodeHex(t, "1154882398cf52a24314d4430eb34f876303d30bfbdb0d05d69d75a6a2247160")
+	info.EncryptedHmacKey = mustDecodeHex(t, "19fb7440adb72b7ca682432a19be9601c6e983e2a6dbf411f1f4d977b021d2130b9ec42dc7f8042ade8ffb7302a2efe6068c71219f154b03887795db9c435a9e")
+	info.EncryptedHmacValue = mustDecodeHex(t, "f96b75babf64c11464abb007f859ecaa3a84d3eae308f229de1db2114435b72113d236b06e36cca0208311759b420446ad90a77285dd2d050ab9a7718a7697ce")
+	encryptedPackage := mustDecodeHex(t, "6400000000000000a4beb2dad140823b794ddac9fa07e5790a8fc5aa447159199792d91a38fabba97751028aeaa64926444f429357c342c14d28cf4b05bb89e56cf753754efd2dc3d417f7d1fae183e4a4d4203175d4d8dec73a64a849b49e618d4784c2b9bdc6c6e497a3adbe5ef3ab1f8dcf0ed19c6973")
+	plaintext := make([]byte, 100)
+	for i := range plaintext {
+		plaintext[i] = byte(i * 7 % 251)
+	}
+
+	if got := hex.EncodeToString(info.passwordHash(password)); got != "1154708599656ec9fff5342f72c700ee6d5a0d7ea340f6701f29a7e6159615113d72f0c919cc783d1aee8a570737908f74baf2d342d38d0397984163cfe29fed" {
+		t.Fatalf("неверный хеш пароля: %s", got)
+	}
+	key, err := info.DecryptKey(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, sequenceBytes(32, 32)) {
+		t.Fatalf("неверный ключ: %x", key)
+	}
+	if valid, err := info.VerifyIntegrity(key, encryptedPackage); err != nil || !valid {
+		t.Fatalf("проверка целостности не пройдена: %v", err)
+	}
+	decrypted, err := info.DecryptPackage(key, encryptedPackage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("неверный результат расшифровки: %x", decrypted)
+	}
+	encrypted, err := info.EncryptPackage(key, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encrypted, encryptedPackage) {
+		t.Fatalf("неверный результат шифрования: %x", encrypted)
+	}
+}
+
+func TestAgileEncryptionRoundTrip(t *testing.T) {
+	const password = "пароль"
+	data := bytes.Repeat([]byte("word/document.xml"), 1000)
+	encrypted, err := encryptPackage(testEncryptedCompound(), testEncryptionTemplate(1000), password, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, encryptedPackage := readEncryptedCompound(t, encrypted)
+
+	if _, err := info.DecryptKey("неверный пароль"); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("ожидалась ошибка ErrWrongPassword, получено %v", err)
+	}
+	key, err := info.DecryptKey(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid, err := info.VerifyIntegrity(key, encryptedPackage); err != nil || !valid {
+		t.Fatalf("проверка целостности не пройдена: %v", err)
+	}
+	decrypted, err := info.DecryptPackage(key, encryptedPackage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Fatal("расшифрованные данные не совпадают с исходными")
+	}
+}
+
+func TestEncryptedPackageIntegrityFailure(t *testing.T) {
+	const password = "пароль"
+	encrypted, err := encryptPackage(testEncryptedCompound(), testEncryptionTemplate(1000), password, bytes.Repeat([]byte{'x'}, 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compound, err := ReadCompoundFile(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := compound.Child(encryptedPackageStreamName)
+	stream.Data[len(stream.Data)-1] ^= 1
+	tampered := WriteCompoundFile(compound)
+
+	processor := NewDocxNumberingProcessor()
+	processor.Password = password
+	err = processor.ProcessStream(bytes.NewReader(tampered), int64(len(tampered)), io.Discard)
+	if !errors.Is(err, ErrIntegrity) {
+		t.Fatalf("ожидалась ошибка ErrIntegrity, получено %v", err)
+	}
+}
+
+func TestParseAgileEncryptionInfoSpinCount(t *testing.T) {
+	for _, spinCount := range []int{-1, maxSpinCount + 1} {
+		info := testEncryptionTemplate(spinCount)
+		info.PasswordKey.SaltValue = sequenceBytes(0, 16)
+		info.KeyData.SaltValue = sequenceBytes(16, 16)
+		info.EncryptedVerifierHashInput = make([]byte, 16)
+		info.EncryptedVerifierHashValue = make([]byte, 64)
+		info.EncryptedKeyValue = make([]byte, 32)
+		info.EncryptedHmacKey = make([]byte, 64)
+		info.EncryptedHmacValue = make([]byte, 64)
+		data, err := info.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ParseAgileEncryptionInfo(data); err == nil {
+			t.Errorf("spinCount %d должен быть отклонен", spinCount)
+		}
+	}
+}
+
+func TestProcessEncryptedInPlaceRequiresReEncrypt(t *testing.T) {
+	encrypted, err := encryptPackage(testEncryptedCompound(), testEncryptionTemplate(1000), "пароль", []byte("PK"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "encrypted.docx")
+	if err := os.WriteFile(path, encrypted, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	processor := NewDocxNumberingProcessor()
+	processor.Password = "пароль"
+	if _, err := processor.Process(path, path); !errors.Is(err, ErrDecryptedInPlace) {
+		t.Fatalf("ожидалась ошибка ErrDecryptedInPlace, получено %v", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || !bytes.Equal(data, encrypted) {
+		t.Fatal("исходный зашифрованный файл изменен")
+	}
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/beevik/etree"
+)
+
+const (
+	fieldCharBegin    = "begin"
+	fieldCharSeparate = "separate"
+	fieldCharEnd      = "end"
+)
+
+var fieldFormats = map[string]string{
+	"arabic":     "decimal",
+	"roman":      "lowerRoman",
+	"ROMAN":      "upperRoman",
+	"alphabetic": "lowerLetter",
+	"ALPHABETIC": "upperLetter",
+}
+
+var generalArgumentSwitches = map[string]bool{
+	`\*`: true, `\#`: true, `\@`: true,
+}
+
+var fieldArgumentSwitches = map[string]map[string]bool{
+	"SEQ":     {`\s`: true, `\r`: true},
+	"LISTNUM": {`\s`: true, `\l`: true},
+	"AUTONUM": {`\s`: true},
+	"TOC": {
+		`\a`: true, `\b`: true, `\c`: true, `\d`: true, `\f`: true, `\l`: true,
+		`\n`: true, `\o`: true, `\p`: true, `\s`: true, `\t`: true,
+	},
+}
+
+var listNumTemplates = map[string][]AbstractLvlData{
+	"NumberDefault": {
+		{Format: "decimal", Text: "%1)"}, {Format: "lowerLetter", Text: "%2)"}, {Format: "lowerRoman", Text: "%3)"},
+		{Format: "decimal", Text: "(%4)"}, {Format: "lowerLetter", Text: "(%5)"}, {Format: "lowerRoman", Text: "(%6)"},
+		{Format: "decimal", Text: "%7."}, {Format: "lowerLetter", Text: "%8."}, {Format: "lowerRoman", Text: "%9."},
+	},
+	"LegalDefault": {
+		{Format: "decimal", Text: "%1."}, {Format: "decimal", Text: "%1.%2."}, {Format: "decimal", Text: "%1.%2.%3."},
+		{Format: "decimal", Text: "%1.%2.%3.%4."}, {Format: "decimal", Text: "%1.%2.%3.%4.%5."},
+		{Format: "decimal", Text: "%1.%2.%3.%4.%5.%6."}, {Format: "decimal", Text: "%1.%2.%3.%4.%5.%6.%7."},
+		{Format: "decimal", Text: "%1.%2.%3.%4.%5.%6.%7.%8."}, {Format: "decimal", Text: "%1.%2.%3.%4.%5.%6.%7.%8.%9."},
+	},
+	"OutlineDefault": {
+		{Format: "upperRoman", Text: "%1."}, {Format: "upperLetter", Text: "%2."}, {Format: "decimal", Text: "%3."},
+		{Format: "lowerLetter", Text: "%4)"}, {Format: "decimal", Text: "(%5)"}, {Format: "lowerLetter", Text: "(%6)"},
+		{Format: "lowerRoman", Text: "(%7)"}, {Format: "lowerLetter", Text: "(%8)"}, {Format: "lowerRoman", Text: "(%9)"},
+	},
+}
+
+type FieldInstruction struct {
+	Name      string
+	Arguments []string
+	Switches  map[string]string
+}
+
+func ParseFieldInstruction(instruction string) FieldInstruction {
+	field := FieldInstruction{Switches: make(map[string]string)}
+	tokens := tokenizeFieldInstruction(instruction)
+	if len(tokens) == 0 {
+		return field
+	}
+	field.Name = strings.ToUpper(tokens[0])
+
+	for i := 1; i < len(tokens); i++ {
+		token := tokens[i]
+		if !strings.HasPrefix(token, `\`) || len(token) < 2 {
+			field.Arguments = append(field.Arguments, token)
+			continue
+		}
+		switchName := strings.ToLower(token)
+		value := ""
+		takesArgument := generalArgumentSwitches[switchName] || fieldArgumentSwitches[field.Name][switchName]
+		if takesArgument && i+1 < len(tokens) && !strings.HasPrefix(tokens[i+1], `\`) {
+			i++
+			value = tokens[i]
+		}
+		if switchName == `\*` && isMergeFormat(value) {
+			continue
+		}
+		if _, exists := field.Switches[switchName]; !exists {
+			field.Switches[switchName] = value
+		}
+	}
+	return field
+}
+
+func tokenizeFieldInstruction(instruction string) []string {
+	var tokens []string
+	var current strings.Builder
+	inQuotes, hasToken := false, false
+
+	flush := func() {
+		if hasToken {
+			tokens = append(tokens, current.String())
+		}
+		current.Reset()
+		hasToken = false
+	}
+
+	for _, r := range instruction {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			hasToken = true
+		case unicode.IsSpace(r) && !inQuotes:
+			flush()
+		default:
+			current.WriteRune(r)
+			hasToken = true
+		}
+	}
+	flush()
+	return tokens
+}
+
+func isMergeFormat(format string) bool {
+	format = strings.ToUpper(format)
+	return format == "MERGEFORMAT" || format == "CHARFORMAT"
+}
+
+func (fi FieldInstruction) HasSwitch(switchName string) bool {
+	_, ok := fi.Switches[switchName]
+	return ok
+}
+
+func (fi FieldInstruction) IntSwitch(switchName string) (int, bool) {
+	value, ok := fi.Switches[switchName]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(value)
+	return n, err == nil
+}
+
+func (fi FieldInstruction) FormatNumber(number int) string {
+	format := fi.Switches[`\*`]
+	if numberFormat, ok := fieldFormats[format]; ok {
+		return formatNumber(number, numberFormat)
+	}
+	if numberFormat, ok := fieldFormats[strings.ToLower(format)]; ok {
+		return formatNumber(number, numberFormat)
+	}
+	return strconv.Itoa(number)
+}
+
+type complexField struct {
+	instruction strings.Builder
+	separated   bool
+	runs        []*etree.Element
+}
+
+type fieldResult struct {
+	Text        string
+	Hyperlink   string
+	Superscript bool
+}
+
+type fieldReplacement struct {
+	anchor *etree.Element
+	remove []*etree.Element
+	result fieldResult
+}
+
+type FieldEngine struct {
+	StyleSheet       *StyleSheet
+	ParagraphNumbers map[*etree.Element]*ParagraphNumber
+
+	sequences        map[string]int
+	sequenceResets   map[string]int
+	headingsSeen     [maxHeadingLevel + 1]int
+	listNumbers      map[string]*NumberingDefinition
+	listNumPara      *etree.Element
+	listNumInPara    int
+	autoNumber       int
+	openFields       []*complexField
+	replacements     []fieldReplacement
+	currentParagraph *etree.Element
+	references       *referenceIndex
+	currentNumbers   map[string]*ParagraphNumber
+}
+
+func NewFieldEngine(styleSheet *StyleSheet, paragraphNumbers map[*etree.Element]*ParagraphNumber) *FieldEngine {
+	if styleSheet == nil {
+		styleSheet = NewStyleSheet()
+	}
+	return &FieldEngine{
+		StyleSheet:       styleSheet,
+		ParagraphNumbers: paragraphNumbers,
+		currentNumbers:   make(map[string]*ParagraphNumber),
+		sequences:        make(map[string]int),
+		sequenceResets:   make(map[string]int),
+		listNumbers:      make(map[string]*NumberingDefinition),
+	}
+}
+
+func (fe *FieldEngine) FlattenFields(root *etree.Element) {
+	fe.references = buildReferenceIndex(root)
+	fe.walk(root)
+	for _, replacement := range fe.replacements {
+		fe.applyReplacement(replacement)
+	}
+	fe.replacements = nil
+	fe.openFields = nil
+}
+
+func (fe *FieldEngine) walk(element *etree.Element) {
+	for _, child := range element.ChildElements() {
+		switch {
+		case isWordElement(child, "del"), isWordElement(child, "moveFrom"):
+			continue
+		case isWordElement(child, "p"):
+			outerParagraph := fe.currentParagraph
+			fe.currentParagraph = child
+			fe.onParagraph(child)
+			fe.walk(child)
+			fe.currentParagraph = outerParagraph
+		case isWordElement(child, "fldSimple"):
+			fe.onSimpleField(child)
+		case isWordElement(child, "r"):
+			fe.onRun(child)
+			fe.walk(child)
+		default:
+			fe.walk(child)
+		}
+	}
+}
+
+func (fe *FieldEngine) onParagraph(paragraph *etree.Element) {
+	if number, ok := fe.ParagraphNumbers[paragraph]; ok {
+		fe.currentNumbers[number.NumID] = number
+	}
+	if level := fe.StyleSheet.ParagraphHeadingLevel(paragraph); level > 0 {
+		fe.headingsSeen[level]++
+	}
+}
+
+func (fe *FieldEngine) onSimpleField(fldSimple *etree.Element) {
+	instruction, _ := getAttribute(fldSimple, "instr")
+	result, ok := fe.evaluate(ParseFieldInstruction(instruction))
+	if !ok {
+		return
+	}
+	fe.replacements = append(fe.replacements, fieldReplacement{
+		anchor: fldSimple,
+		remove: []*etree.Element{fldSimple},
+		result: result,
+	})
+}
+
+func (fe *FieldEngine) onRun(run *etree.Element) {
+	for _, field := range fe.openFields {
+		field.runs = append(field.runs, run)
+	}
+
+	for _, child := range run.ChildElements() {
+		switch {
+		case isWordElement(child, "fldChar"):
+			fe.onFieldChar(run, child)
+		case isWordElement(child, "instrText"):
+			if n := len(fe.openFields); n > 0 && !fe.openFields[n-1].separated {
+				fe.openFields[n-1].instruction.WriteString(child.Text())
+			}
+		}
+	}
+}
+
+func (fe *FieldEngine) onFieldChar(run, fldChar *etree.Element) {
+	fldCharType, _ := getAttribute(fldChar, "fldCharType")
+	n := len(fe.openFields)
+
+	switch fldCharType {
+	case fieldCharBegin:
+		field := &complexField{runs: []*etree.Element{run}}
+		fe.openFields = append(fe.openFields, field)
+	case fieldCharSeparate:
+		if n > 0 {
+			fe.openFields[n-1].separated = true
+		}
+	case fieldCharEnd:
+		if n == 0 {
+			return
+		}
+		field := fe.openFields[n-1]
+		fe.openFields = fe.openFields[:n-1]
+
+		result, ok := fe.evaluate(ParseFieldInstruction(field.instruction.String()))
+		if !ok {
+			return
+		}
+		fe.replacements = append(fe.replacements, fieldReplacement{
+			anchor: field.runs[0],
+			remove: field.runs,
+			result: result,
+		})
+	}
+}
+
+func (fe *FieldEngine) evaluate(field FieldInstruction) (fieldResult, bool) {
+	var text string
+	var ok bool
+	switch field.Name {
+	case "SEQ":
+		text, ok = fe.evaluateSequence(field)
+	case "LISTNUM":
+		text, ok = fe.evaluateListNum(field)
+	case "AUTONUM":
+		text, ok = fe.evaluateAutoNum(field)
+	case "REF":
+		return fe.evaluateReference(field)
+	case "NOTEREF":
+		return fe.evaluateNoteReference(field)
+	}
+	return fieldResult{Text: text}, ok
+}
+
+func (fe *FieldEngine) evaluateSequence(field FieldInstruction) (string, bool) {
+	if len(field.Arguments) == 0 {
+		return "", false
+	}
+	identifier := field.Arguments[0]
+
+	if level, ok := field.IntSwitch(`\s`); ok && level > 0 && level <= maxHeadingLevel {
+		headings := 0
+		for l := 1; l <= level; l++ {
+			headings += fe.headingsSeen[l]
+		}
+		resetKey := fmt.Sprintf("%s\\s%d", identifier, level)
+		if last, seen := fe.sequenceResets[resetKey]; !seen || last != headings {
+			if seen {
+				fe.sequences[identifier] = 0
+			}
+			fe.sequenceResets[resetKey] = headings
+		}
+	}
+
+	if start, ok := field.IntSwitch(`\r`); ok {
+		fe.sequences[identifier] = start
+	} else if !field.HasSwitch(`\c`) {
+		fe.sequences[identifier]++
+	}
+
+	if field.HasSwitch(`\h`) && !field.HasSwitch(`\*`) {
+		return "", true
+	}
+	return field.FormatNumber(fe.sequences[identifier]), true
+}
+
+func (fe *FieldEngine) evaluateListNum(field FieldInstruction) (string, bool) {
+	listName := "NumberDefault"
+	if len(field.Arguments) > 0 {
+		listName = field.Arguments[0]
+	}
+	listNumbers, ok := fe.listNumbers[listName]
+	if !ok {
+		templates, known := listNumTemplates[listName]
+		if !known {
+			templates = listNumTemplates["NumberDefault"]
+		}
+		listNumbers = NewNumberingDefinition(listName)
+		for i, template := range templates {
+			listNumbers.AddLevel(strconv.Itoa(i), NewNumberingLevel(template.Format, template.Text, 0))
+		}
+		fe.listNumbers[listName] = listNumbers
+	}
+
+	if fe.listNumPara != fe.currentParagraph {
+		fe.listNumPara = fe.currentParagraph
+		fe.listNumInPara = 0
+	}
+	fe.listNumInPara++
+
+	level := fe.listNumInPara
+	if l, ok := field.IntSwitch(`\l`); ok {
+		level = l
+	}
+	if level < 1 || level > maxHeadingLevel {
+		return "", false
+	}
+	levelID := strconv.Itoa(level - 1)
+
+	if start, ok := field.IntSwitch(`\s`); ok {
+		listNumbers.Levels[levelID].CurrentValue = start
+	} else {
+		listNumbers.Levels[levelID].Increment()
+	}
+	listNumbers.ResetLevelsBelow(levelID)
+	return listNumbers.GetFormattedNumber(levelID), true
+}
+
+func (fe *FieldEngine) evaluateAutoNum(field FieldInstruction) (string, bool) {
+	fe.autoNumber++
+	separator := "."
+	if value, ok := field.Switches[`\s`]; ok {
+		separator = value
+	}
+	return field.FormatNumber(fe.autoNumber) + separator, true
+}
+
+func (fe *FieldEngine) applyReplacement(replacement fieldReplacement) {
+	parent := replacement.anchor.Parent()
+	if parent == nil {
+		return
+	}
+
+	if result := replacement.result; result.Text != "" {
+		run := createElement(parent, "r", nil)
+		rPr := fieldResultProperties(replacement.remove)
+		if rPr != nil {
+			rPr = rPr.Copy()
+		}
+		if result.Superscript {
+			if rPr == nil {
+				rPr = createElement(parent, "rPr", nil)
+			}
+			if vertAlign := findElement(rPr, "./w:vertAlign"); vertAlign == nil {
+				vertAlign = createElement(parent, "vertAlign", nil)
+				setAttribute(vertAlign, "val", "superscript")
+				rPr.AddChild(vertAlign)
+			}
+		}
+		if rPr != nil {
+			run.AddChild(rPr)
+		}
+		t := createElement(parent, "t", nil)
+		t.CreateAttr("xml:space", "preserve")
+		t.SetText(result.Text)
+		run.AddChild(t)
+
+		content := run
+		if result.Hyperlink != "" && !isWordElement(parent, "hyperlink") {
+			content = createElement(parent, "hyperlink", nil)
+			setAttribute(content, "anchor", result.Hyperlink)
+			setAttribute(content, "history", "1")
+			content.AddChild(run)
+		}
+		parent.InsertChildAt(replacement.anchor.Index(), content)
+	}
+
+	for _, element := range replacement.remove {
+		removeElement(element)
+	}
+}
+
+func fieldResultProperties(elements []*etree.Element) *etree.Element {
+	for _, element := range elements {
+		if isWordElement(element, "fldSimple") {
+			if run := findElement(element, ".//w:r"); run != nil {
+				return findElement(run, "./w:rPr")
+			}
+			continue
+		}
+		if findElement(element, "./w:t") != nil {
+			return findElement(element, "./w:rPr")
+		}
+	}
+	if len(elements) > 0 && isWordElement(elements[0], "r") {
+		return findElement(elements[0], "./w:rPr")
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+const (
+	flatOPCNS          = "http://schemas.microsoft.com/office/2006/xmlPackage"
+	flatOPCPrefix      = "pkg"
+	flatOPCProgID      = `progid="Word.Document"`
+	relationshipsCType = "application/vnd.openxmlformats-package.relationships+xml"
+	base64LineLength   = 76
+)
+
+const (
+	InputFormatDocx     = "docx"
+	InputFormatFlatOPC  = "flatopc"
+	InputFormatWord2003 = "word2003"
+	InputFormatCompound = "compound"
+
+	OutputFormatAuto    = ""
+	OutputFormatDocx    = "docx"
+	OutputFormatFlatOPC = "flatopc"
+)
+
+func DetectInputFormat(inputPath string) (string, error) {
+	f, err := os.Open(inputPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	return detectInputFormat(f, info.Size())
+}
+
+func detectInputFormat(r io.ReaderAt, size int64) (string, error) {
+	header := make([]byte, len(compoundFileSignature))
+	n, err := r.ReadAt(header, 0)
+	if n == 0 && err != nil {
+		return "", err
+	}
+	if bytes.HasPrefix(header[:n], []byte("PK\x03\x04")) {
+		return InputFormatDocx, nil
+	}
+	if isCompoundFile(header[:n]) {
+		return InputFormatCompound, nil
+	}
+
+	decoder := xml.NewDecoder(io.NewSectionReader(r, 0, size))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", fmt.Errorf("файл не является ни ZIP-пакетом, ни XML-документом: %w", err)
+		}
+		root, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch {
+		case root.Name.Local == "package" && root.Name.Space == flatOPCNS:
+			return InputFormatFlatOPC, nil
+		case root.Name.Local == "wordDocument" && root.Name.Space == word2003NS:
+			return InputFormatWord2003, nil
+		}
+		return "", fmt.Errorf("неизвестный формат XML-документа")
+	}
+}
+
+func readFlatOPC(r io.Reader) (*etree.Document, *Package, error) {
+	doc := etree.NewDocument()
+	if _, err := doc.ReadFrom(r); err != nil {
+		return nil, nil, err
+	}
+
+	contentTypes, err := ParseContentTypes([]byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?><Types xmlns="` + contentTypesNS + `"/>`))
+	if err != nil {
+		return nil, nil, err
+	}
+	contentTypes.AddDefault("rels", relationshipsCType)
+
+	pkg := NewPackage()
+	for _, part := range flatOPCParts(doc.Root()) {
+		partName := strings.TrimPrefix(flatOPCAttr(part, "name"), "/")
+		if partName == "" {
+			continue
+		}
+		if !isValidPartName(partName) || partName == contentTypesPartName {
+			return nil, nil, fmt.Errorf("недопустимый путь к части: %s", partName)
+		}
+
+		var content []byte
+		if xmlData := flatOPCChild(part, "xmlData"); xmlData != nil {
+			if len(xmlData.ChildElements()) == 0 {
+				continue
+			}
+			partDoc := etree.NewDocument()
+			partDoc.CreateProcInst("xml", `version="1.0" encoding="UTF-8" standalone="yes"`)
+			partDoc.SetRoot(xmlData.ChildElements()[0].Copy())
+			if content, err = partDoc.WriteToBytes(); err != nil {
+				return nil, nil, err
+			}
+		} else if binaryData := flatOPCChild(part, "binaryData"); binaryData != nil {
+			data := strings.Join(strings.Fields(binaryData.Text()), "")
+			if content, err = base64.StdEncoding.DecodeString(data); err != nil {
+				return nil, nil, fmt.Errorf("ошибка декодирования части %s: %w", partName, err)
+			}
+		}
+
+		pkg.WritePart(partName, content)
+		if contentType := flatOPCAttr(part, "contentType"); contentType != "" && contentType != relationshipsCType {
+			contentTypes.SetOverride(partName, contentType)
+		}
+	}
+
+	if err := writeContentTypes(pkg, contentTypes); err != nil {
+		return nil, nil, err
+	}
+	return doc, pkg, nil
+}
+
+func writeFlatOPC(pkg *Package, w io.Writer, original *etree.Document) error {
+	contentTypes, err := readContentTypes(pkg)
+	if err != nil {
+		return err
+	}
+
+	var partNames []string
+	for _, partName := range pkg.PartNames() {
+		if partName != contentTypesPartName {
+			partNames = append(partNames, partName)
+		}
+	}
+	sort.Strings(partNames)
+	remaining := make(map[string]bool)
+	for _, partName := range partNames {
+		remaining[partName] = true
+	}
+
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="UTF-8" standalone="yes"`)
+	doc.CreateProcInst("mso-application", flatOPCProgID)
+	root := doc.CreateElement(flatOPCPrefix + ":package")
+	root.CreateAttr("xmlns:"+flatOPCPrefix, flatOPCNS)
+
+	var ordered []string
+	compression := make(map[string]string)
+	if original != nil {
+		for _, part := range flatOPCParts(original.Root()) {
+			partName := strings.TrimPrefix(flatOPCAttr(part, "name"), "/")
+			if remaining[partName] {
+				ordered = append(ordered, partName)
+				compression[partName] = flatOPCAttr(part, "compression")
+				delete(remaining, partName)
+			}
+		}
+	}
+	for _, partName := range partNames {
+		if remaining[partName] {
+			ordered = append(ordered, partName)
+		}
+	}
+
+	for _, partName := range ordered {
+		data, err := pkg.ReadPart(partName)
+		if err != nil {
+			return err
+		}
+		contentType := contentTypes.ContentType(partName)
+		if strings.HasSuffix(partName, ".rels") {
+			contentType = relationshipsCType
+		}
+
+		part := root.CreateElement(flatOPCPrefix + ":part")
+		part.CreateAttr(flatOPCPrefix+":name", "/"+partName)
+		part.CreateAttr(flatOPCPrefix+":contentType", contentType)
+		if compression[partName] != "" {
+			part.CreateAttr(flatOPCPrefix+":compression", compression[partName])
+		}
+
+		if strings.HasSuffix(contentType, "xml") {
+			partDoc := etree.NewDocument()
+			if err := partDoc.ReadFromBytes(data); err == nil && partDoc.Root() != nil {
+				part.CreateElement(flatOPCPrefix + ":xmlData").AddChild(partDoc.Root())
+				continue
+			}
+		}
+
+		encoded := base64.StdEncoding.EncodeToString(data)
+		var lines []string
+		for len(encoded) > base64LineLength {
+			lines = append(lines, encoded[:base64LineLength])
+			encoded = encoded[base64LineLength:]
+		}
+		lines = append(lines, encoded)
+		part.CreateElement(flatOPCPrefix + ":binaryData").SetText(strings.Join(lines, "\n"))
+	}
+
+	_, err = doc.WriteTo(w)
+	return err
+}
+
+func flatOPCParts(root *etree.Element) []*etree.Element {
+	var parts []*etree.Element
+	for _, child := range root.ChildElements() {
+		if child.Tag == "part" && child.NamespaceURI() == flatOPCNS {
+			parts = append(parts, child)
+		}
+	}
+	return parts
+}
+
+func flatOPCChild(part *etree.Element, tag string) *etree.Element {
+	for _, child := range part.ChildElements() {
+		if child.Tag == tag && child.NamespaceURI() == flatOPCNS {
+			return child
+		}
+	}
+	return nil
+}
+
+func flatOPCAttr(part *etree.Element, key string) string {
+	for _, attr := range part.Attr {
+		if attr.Key == key && attr.Space != "" && lookupNamespaceURI(part, attr.Space) == flatOPCNS {
+			return attr.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/beevik/etree"
+)
+
+func (dnp *DocxNumberingProcessor) processGlossaryDocument(pkg *Package, glossaries []GlossaryParts) error {
+	for _, glossary := range glossaries {
+		content, err := pkg.ReadPart(glossary.Document)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("ошибка чтения %s: %w", glossary.Document, err)
+		}
+
+		numberingContent, err := readOptionalPart(pkg, glossary.Numbering)
+		if err != nil {
+			return err
+		}
+		numberingParser := NewNumberingParser()
+		if numberingContent != nil {
+			if err := numberingParser.ParseNumberingXML(numberingContent); err != nil {
+				return fmt.Errorf("ошибка парсинга %s: %w", glossary.Numbering, err)
+			}
+		}
+		styleSheet := NewStyleSheet()
+		stylesContent, err := readOptionalPart(pkg, glossary.Styles)
+		if err != nil {
+			return err
+		}
+		if stylesContent != nil {
+			if err := styleSheet.ParseStylesXML(stylesContent); err != nil {
+				return fmt.Errorf("ошибка парсинга %s: %w", glossary.Styles, err)
+			}
+		}
+
+		modified, err := dnp.processGlossaryContent(content, numberingContent, styleSheet)
+		if err != nil {
+			return fmt.Errorf("ошибка обработки %s: %w", glossary.Document, err)
+		}
+		pkg.WritePart(glossary.Document, modified)
+
+		if err := rewriteNumberedStyles(pkg, glossary.Styles, numberingParser.NumberingDefinitions, styleSheet); err != nil {
+			return fmt.Errorf("ошибка обновления %s: %w", glossary.Styles, err)
+		}
+		if err := dnp.cleanupGlossaryNumbering(pkg, glossary); err != nil {
+			return fmt.Errorf("ошибка очистки %s: %w", glossary.Numbering, err)
+		}
+	}
+	return nil
+}
+
+func (dnp *DocxNumberingProcessor) processGlossaryContent(glossaryContent, numberingContent []byte, styleSheet *StyleSheet) ([]byte, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(glossaryContent); err != nil {
+		return nil, err
+	}
+	glossaryRoot := doc.Root()
+	snapshot := takeXMLSnapshot(doc)
+
+	ApplyTrackChanges(glossaryRoot, dnp.TrackChanges)
+
+	for _, docPartBody := range findAllElements(glossaryRoot, "//w:docPart/w:docPartBody") {
+		numberingParser := NewNumberingParser()
+		if numberingContent != nil {
+			if err := numberingParser.ParseNumberingXML(numberingContent); err != nil {
+				return nil, fmt.Errorf("ошибка парсинга нумерации глоссария: %w", err)
+			}
+		}
+		paragraphNumbers := dnp.numberParagraphs(docPartBody, numberingParser.NumberingDefinitions, styleSheet)
+		if dnp.FlattenFields {
+			NewFieldEngine(styleSheet, paragraphNumbers).FlattenFields(docPartBody)
+		}
+	}
+
+	return snapshot.serialize(doc, glossaryContent)
+}
+
+func readOptionalPart(pkg *Package, partName string) ([]byte, error) {
+	if partName == "" {
+		return nil, nil
+	}
+	content, err := pkg.ReadPart(partName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("ошибка чтения %s: %w", partName, err)
+	}
+	return content, nil
+}
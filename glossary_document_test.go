@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const testGlossaryStylesXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:style w:type="paragraph" w:styleId="GlossaryList"><w:name w:val="Glossary List"/><w:pPr><w:numPr><w:numId w:val="1"/></w:numPr></w:pPr></w:style></w:styles>`
+
+func testPackageWithGlossary(t *testing.T) []byte {
+	parts := testNumberedParts(testNumberedDocument(1))
+	for i := range parts {
+		if parts[i].name == "word/_rels/document.xml.rels" {
+			parts[i].content = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/numbering" Target="numbering.xml"/><Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/glossaryDocument" Target="glossary/document.xml"/></Relationships>`
+		}
+	}
+	return testPackage(t, append(parts,
+		testPart{"word/glossary/document.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:glossaryDocument xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:docParts><w:docPart><w:docPartBody><w:p><w:pPr><w:pStyle w:val="GlossaryList"/></w:pPr><w:r><w:t>Блок</w:t></w:r></w:p></w:docPartBody></w:docPart></w:docParts></w:glossaryDocument>`},
+		testPart{"word/glossary/_rels/document.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/numbering" Target="numbering.xml"/><Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/></Relationships>`},
+		testPart{"word/glossary/numbering.xml", testNumberingXML},
+		testPart{"word/glossary/styles.xml", testGlossaryStylesXML},
+	))
+}
+
+func TestGlossaryStyleNumbering(t *testing.T) {
+	input := testPackageWithGlossary(t)
+
+	processor := NewDocxNumberingProcessor()
+	var output bytes.Buffer
+	if err := processor.ProcessStream(bytes.NewReader(input), int64(len(input)), &output); err != nil {
+		t.Fatal(err)
+	}
+	glossary := string(readPackagePart(t, output.Bytes(), "word/glossary/document.xml"))
+	if !strings.Contains(glossary, `<w:t xml:space="preserve">1.</w:t>`) {
+		t.Fatalf("абзац глоссария со стилем нумерации не пронумерован: %s", glossary)
+	}
+	if styles := string(readPackagePart(t, output.Bytes(), "word/glossary/styles.xml")); strings.Contains(styles, "w:numPr") {
+		t.Errorf("нумерация не удалена из стилей глоссария: %s", styles)
+	}
+	if numbering := string(readPackagePart(t, output.Bytes(), "word/glossary/numbering.xml")); strings.Contains(numbering, "<w:num ") {
+		t.Errorf("неиспользуемая нумерация глоссария не удалена: %s", numbering)
+	}
+}
@@ -2,4 +2,9 @@ module docxnumbering
 
 go 1.24.3
 
-require github.com/beevik/etree v1.5.1
+require (
+	github.com/beevik/etree v1.5.1
+	golang.org/x/term v0.36.0
+)
+
+require golang.org/x/sys v0.37.0 // indirect
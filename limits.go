@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	DefaultMaxTotalSize        = 256 << 20
+	DefaultMaxCompressionRatio = 200
+	DefaultMaxEntries          = 10000
+	DefaultMaxNestingDepth     = 3
+	DefaultMaxXMLElements      = 5000000
+
+	compressionRatioThreshold = 1 << 20
+)
+
+const (
+	LimitTotalSize        = "total-size"
+	LimitCompressionRatio = "compression-ratio"
+	LimitEntries          = "entries"
+	LimitNestingDepth     = "nesting-depth"
+	LimitXMLElements      = "xml-elements"
+)
+
+var limitDescriptions = map[string]string{
+	LimitTotalSize:        "общий размер распакованных данных",
+	LimitCompressionRatio: "степень сжатия записи",
+	LimitEntries:          "количество записей в пакете",
+	LimitNestingDepth:     "глубина вложенности пакетов",
+	LimitXMLElements:      "количество XML-элементов",
+}
+
+var ErrLimitExceeded = errors.New("превышен лимит безопасности")
+
+type Limits struct {
+	MaxTotalSize        int64
+	MaxCompressionRatio int64
+	MaxEntries          int
+	MaxNestingDepth     int
+	MaxXMLElements      int
+}
+
+func DefaultLimits() Limits {
+	return Limits{
+		MaxTotalSize:        DefaultMaxTotalSize,
+		MaxCompressionRatio: DefaultMaxCompressionRatio,
+		MaxEntries:          DefaultMaxEntries,
+		MaxNestingDepth:     DefaultMaxNestingDepth,
+		MaxXMLElements:      DefaultMaxXMLElements,
+	}
+}
+
+type LimitError struct {
+	Limit string
+	Part  string
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	message := fmt.Sprintf("%s: %s (не более %d)", ErrLimitExceeded, limitDescriptions[e.Limit], e.Max)
+	if e.Part != "" {
+		message += ", часть " + e.Part
+	}
+	return message
+}
+
+func (e *LimitError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+type packageLimiter struct {
+	limits Limits
+	total  int64
+}
+
+func newPackageLimiter(limits Limits) *packageLimiter {
+	return &packageLimiter{limits: limits}
+}
+
+func (l *packageLimiter) checkEntries(count int) error {
+	if l == nil || l.limits.MaxEntries <= 0 || count <= l.limits.MaxEntries {
+		return nil
+	}
+	return &LimitError{Limit: LimitEntries, Max: int64(l.limits.MaxEntries)}
+}
+
+func (l *packageLimiter) checkDepth(depth int) error {
+	if l == nil || l.limits.MaxNestingDepth <= 0 || depth <= l.limits.MaxNestingDepth {
+		return nil
+	}
+	return &LimitError{Limit: LimitNestingDepth, Max: int64(l.limits.MaxNestingDepth)}
+}
+
+func (l *packageLimiter) addSize(n int64, partName string) error {
+	if l == nil {
+		return nil
+	}
+	l.total += n
+	if l.limits.MaxTotalSize > 0 && l.total > l.limits.MaxTotalSize {
+		return &LimitError{Limit: LimitTotalSize, Part: partName, Max: l.limits.MaxTotalSize}
+	}
+	return nil
+}
+
+func (l *packageLimiter) reader(r io.Reader, partName string, compressedSize int64) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedPartReader{r: r, limiter: l, partName: partName, compressedSize: compressedSize}
+}
+
+func (l *packageLimiter) peekReader(r io.Reader, partName string, compressedSize int64) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedPartReader{r: r, limiter: l, partName: partName, compressedSize: compressedSize, peek: true}
+}
+
+func (l *packageLimiter) readXML(r io.Reader, partName string) ([]byte, error) {
+	if l == nil || l.limits.MaxXMLElements <= 0 {
+		return io.ReadAll(r)
+	}
+	var data bytes.Buffer
+	source := io.TeeReader(r, &data)
+	decoder := xml.NewDecoder(source)
+	elements := 0
+	for {
+		token, err := decoder.RawToken()
+		if err != nil {
+			break
+		}
+		if _, ok := token.(xml.StartElement); ok {
+			elements++
+			if err := l.checkElements(elements, partName); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if _, err := io.Copy(io.Discard, source); err != nil {
+		return nil, err
+	}
+	return data.Bytes(), nil
+}
+
+func (l *packageLimiter) checkElements(count int, partName string) error {
+	if l == nil || l.limits.MaxXMLElements <= 0 || count <= l.limits.MaxXMLElements {
+		return nil
+	}
+	return &LimitError{Limit: LimitXMLElements, Part: partName, Max: int64(l.limits.MaxXMLElements)}
+}
+
+type limitedPartReader struct {
+	r              io.Reader
+	limiter        *packageLimiter
+	partName       string
+	compressedSize int64
+	read           int64
+	peek           bool
+}
+
+func (r *limitedPartReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.read += int64(n)
+	if r.peek {
+		if maxTotal := r.limiter.limits.MaxTotalSize; maxTotal > 0 && r.limiter.total+r.read > maxTotal {
+			return n, &LimitError{Limit: LimitTotalSize, Part: r.partName, Max: maxTotal}
+		}
+	} else if limitErr := r.limiter.addSize(int64(n), r.partName); limitErr != nil {
+		return n, limitErr
+	}
+	ratio := r.limiter.limits.MaxCompressionRatio
+	if ratio > 0 && r.read > compressionRatioThreshold && r.read > ratio*r.compressedSize {
+		return n, &LimitError{Limit: LimitCompressionRatio, Part: r.partName, Max: ratio}
+	}
+	return n, err
+}
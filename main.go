@@ -2,20 +2,190 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
+
+	"golang.org/x/term"
 )
 
+var supportedExtensions = map[string]bool{
+	".docx": true,
+	".docm": true,
+	".dotx": true,
+	".dotm": true,
+	".xml":  true,
+}
+
 func AddNumberingToDocx(inputDocxPath, outputDocxPath string) (bool, error) {
 	processor := NewDocxNumberingProcessor()
 	return processor.Process(inputDocxPath, outputDocxPath)
 }
 
+func configureProcessor(processor *DocxNumberingProcessor) {
+	processor.InlineAltChunks = askYesNo("Встраивать содержимое altChunk (вложенных DOCX) в основной документ?")
+	processor.Streaming = askYesNo("Использовать потоковую обработку для очень больших документов (без замены полей, закладок, пересборки оглавления и принятия исправлений)?")
+	if processor.Streaming {
+		processor.FlattenFields = false
+	} else {
+		processor.FlattenFields = askYesNo("Заменять поля SEQ, LISTNUM, AUTONUM и перекрестные ссылки REF/NOTEREF вычисленными номерами?")
+		processor.ClauseBookmarks = askYesNo("Добавить закладки (якоря) к каждому пронумерованному абзацу, например clause_3_2_1?")
+		if processor.ClauseBookmarks {
+			if prefix := getInput(fmt.Sprintf("Введите префикс закладок из латинских букв и цифр, начиная с буквы (или Enter для '%s'): ", processor.BookmarkPrefix)); IsValidBookmarkPrefix(prefix) {
+				processor.BookmarkPrefix = prefix
+			} else if prefix != "" {
+				fmt.Printf("Предупреждение: Префикс '%s' недопустим. Будет использован префикс '%s'.\n", prefix, processor.BookmarkPrefix)
+			}
+		}
+		processor.RegenerateTOC = askYesNo("Пересобрать оглавление (поле TOC) по обработанным заголовкам?")
+		if processor.RegenerateTOC {
+			processor.KeepTOCPages = askYesNo("Сохранить номера страниц из прежнего оглавления (иначе они будут удалены)?")
+		}
+	}
+	processor.SignaturePolicy = askSignaturePolicy("\nДействие для документов с цифровой подписью (_xmlsignatures):", processor.SignaturePolicy)
+	processor.NumberingCleanup = askNumberingCleanup("\nОчистка определений нумерации после расстановки номеров:", processor.NumberingCleanup)
+	if !processor.Streaming {
+		processor.TrackChanges = askTrackChanges("\nРежим исправлений при расстановке номеров:", processor.TrackChanges)
+	}
+
+	processor.UseCurrentTime = askYesNo("Записывать текущее время в дату изменения документа и даты исправлений (по умолчанию даты берутся из исходного файла, и результат воспроизводим)?")
+	processor.RecordRevisions = askYesNo("Записывать расстановку номеров как исправления (w:ins / w:pPrChange)?")
+	if processor.RecordRevisions {
+		if author := getInput(fmt.Sprintf("Введите автора исправлений (или Enter для '%s'): ", processor.RevisionAuthor)); author != "" {
+			processor.RevisionAuthor = author
+		}
+		processor.RevisionDate = askRevisionDate(processor.UseCurrentTime)
+	}
+
+	processor.DirectionMarks = askYesNo("Добавлять знаки направления письма (U+200F) вокруг номеров в абзацах справа налево (знаки будут записаны в текст документа)?")
+	processor.NumberRunStyle = getInput("Введите символьный стиль для номеров, например ListNumberText (или Enter, чтобы не назначать стиль): ")
+	processor.StampDocument = askYesNo("Обновить свойства документа (дата изменения, автор) и записать отметку об обработке в docProps/custom.xml?")
+	if askYesNo("Пропускать документы, уже обработанные DocxNumConvert (иначе только предупреждать)?") {
+		processor.ProcessedPolicy = ProcessedPolicySkip
+	}
+	if askYesNo("Изменить ограничения безопасности (размер, степень сжатия, число записей и XML-элементов) для очень больших доверенных файлов?") {
+		processor.Limits = askLimits("\nОграничения безопасности (0 снимает ограничение):", processor.Limits)
+	}
+}
+
+func askLimits(title string, limits Limits) Limits {
+	fmt.Println(title)
+	limits.MaxTotalSize = askLimit(LimitTotalSize, "МБ", limits.MaxTotalSize>>20) << 20
+	limits.MaxCompressionRatio = askLimit(LimitCompressionRatio, "раз", limits.MaxCompressionRatio)
+	limits.MaxEntries = int(askLimit(LimitEntries, "шт.", int64(limits.MaxEntries)))
+	limits.MaxNestingDepth = int(askLimit(LimitNestingDepth, "уровней", int64(limits.MaxNestingDepth)))
+	limits.MaxXMLElements = int(askLimit(LimitXMLElements, "шт.", int64(limits.MaxXMLElements)))
+	return limits
+}
+
+func askLimit(limit, unit string, value int64) int64 {
+	userValue := getInput(fmt.Sprintf("Введите лимит: %s, %s (или Enter для %d): ", limitDescriptions[limit], unit, value))
+	if userValue == "" {
+		return value
+	}
+	parsed, err := strconv.ParseInt(userValue, 10, 32)
+	if err != nil || parsed < 0 {
+		fmt.Printf("Предупреждение: Введенное значение '%s' не распознано. Будет использовано значение %d.\n", userValue, value)
+		return value
+	}
+	return parsed
+}
+
+func askTrackChanges(title, trackChanges string) string {
+	fmt.Println(title)
+	fmt.Println(" - all (сохранить все изменения и комментарии)")
+	fmt.Println(" - accept (принять все изменения)")
+	fmt.Println(" - reject (отклонить все изменения)")
+	userTrackChanges := getInput(fmt.Sprintf("Введите режим отслеживания изменений (или Enter для '%s'): ", trackChanges))
+	if userTrackChanges != "" {
+		validOptions := []string{TrackChangesAll, TrackChangesAccept, TrackChangesReject}
+		isValid := false
+		for _, opt := range validOptions {
+			if strings.ToLower(userTrackChanges) == opt {
+				trackChanges = strings.ToLower(userTrackChanges)
+				isValid = true
+				break
+			}
+		}
+		if !isValid {
+			fmt.Printf("Предупреждение: Введенный режим '%s' не распознан. Будет использован режим '%s'.\n", userTrackChanges, trackChanges)
+		}
+	}
+	return trackChanges
+}
+
+func askRevisionDate(useCurrentTime bool) time.Time {
+	defaultDate, defaultLabel := time.Time{}, "исправлений без даты"
+	if useCurrentTime {
+		defaultDate, defaultLabel = time.Now(), "текущего времени"
+	}
+	userDate := getInput(fmt.Sprintf("Введите дату исправлений, например 2024-01-02 или 2024-01-02T15:04:05Z (или Enter для %s): ", defaultLabel))
+	if userDate == "" {
+		return defaultDate
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if date, err := time.Parse(layout, userDate); err == nil {
+			return date
+		}
+	}
+	fmt.Printf("Предупреждение: Введенная дата '%s' не распознана. Будет использовано значение для %s.\n", userDate, defaultLabel)
+	return defaultDate
+}
+
+func askSignaturePolicy(title, policy string) string {
+	fmt.Println(title)
+	fmt.Println(" - refuse (отказаться от обработки подписанного документа)")
+	fmt.Println(" - strip (удалить подпись и ее связи, затем обработать)")
+	fmt.Println(" - warn (обработать с предупреждением, подпись станет недействительной)")
+	userPolicy := strings.ToLower(getInput(fmt.Sprintf("Введите режим (или Enter для '%s'): ", policy)))
+	switch userPolicy {
+	case "":
+	case SignaturePolicyRefuse, SignaturePolicyStrip, SignaturePolicyWarn:
+		policy = userPolicy
+	default:
+		fmt.Printf("Предупреждение: Введенный режим '%s' не распознан. Будет использован режим '%s'.\n", userPolicy, policy)
+	}
+	return policy
+}
+
+func askNumberingCleanup(title, cleanup string) string {
+	fmt.Println(title)
+	fmt.Println(" - keep (оставить numbering.xml без изменений)")
+	fmt.Println(" - unused (удалить неиспользуемые w:num и w:abstractNum)")
+	fmt.Println(" - remove (удалить numbering.xml целиком, если нумерация больше нигде не используется)")
+	userCleanup := strings.ToLower(getInput(fmt.Sprintf("Введите режим очистки нумерации (или Enter для '%s'): ", cleanup)))
+	switch userCleanup {
+	case "":
+	case NumberingCleanupKeep, NumberingCleanupUnused, NumberingCleanupRemovePart:
+		cleanup = userCleanup
+	default:
+		fmt.Printf("Предупреждение: Введенный режим '%s' не распознан. Будет использован режим '%s'.\n", userCleanup, cleanup)
+	}
+	return cleanup
+}
+
+func askBackupMode(title, mode string) string {
+	fmt.Println(title)
+	fmt.Println(" - bak (сохранить копию рядом с исходным файлом с расширением .bak; если она уже есть, будет создана .bak.1 и т. д.)")
+	fmt.Println(" - timestamp (сохранить копию с меткой времени, например file.docx.20240102-150405.bak)")
+	fmt.Println(" - none (не сохранять резервную копию)")
+	userMode := strings.ToLower(getInput(fmt.Sprintf("Введите режим (или Enter для '%s'): ", mode)))
+	switch userMode {
+	case "":
+	case BackupBak, BackupTimestamp, BackupNone:
+		mode = userMode
+	default:
+		fmt.Printf("Предупреждение: Введенный режим '%s' не распознан. Будет использован режим '%s'.\n", userMode, mode)
+	}
+	return mode
+}
+
 func getInput(prompt string) string {
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Print(prompt)
@@ -23,6 +193,20 @@ func getInput(prompt string) string {
 	return strings.TrimSpace(input)
 }
 
+func getPassword(prompt string) string {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return getInput(prompt)
+	}
+	fmt.Print(prompt)
+	password, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return ""
+	}
+	return string(password)
+}
+
 func askYesNo(prompt string) bool {
 	for {
 		answer := strings.ToLower(getInput(prompt + " (да/нет): "))
@@ -78,12 +262,12 @@ func main() {
 
 	var inputDocxPath string
 	for {
-		inputDocxPath = getInput("Введите полный путь к DOCX файлу для обработки: ")
+		inputDocxPath = getInput("Введите полный путь к DOCX/DOCM/DOTX/DOTM/XML файлу для обработки: ")
 		if _, err := os.Stat(inputDocxPath); err == nil {
-			if strings.ToLower(filepath.Ext(inputDocxPath)) == ".docx" {
+			if supportedExtensions[strings.ToLower(filepath.Ext(inputDocxPath))] {
 				break
 			}
-			fmt.Println("Файл должен иметь расширение .docx")
+			fmt.Println("Файл должен иметь расширение .docx, .docm, .dotx, .dotm или .xml (Flat OPC, Word 2003 XML)")
 		} else if os.IsNotExist(err) {
 			fmt.Printf("Файл не найден: %s\n", inputDocxPath)
 		} else {
@@ -92,22 +276,97 @@ func main() {
 		fmt.Println("Пожалуйста, попробуйте снова.")
 	}
 
+	inputFormat, err := DetectInputFormat(inputDocxPath)
+	if err != nil {
+		logErrorAndExit(fmt.Sprintf("Не удалось определить формат файла '%s'", inputDocxPath), err)
+	}
+
+	processor := NewDocxNumberingProcessor()
 	base := filepath.Base(inputDocxPath)
 	ext := filepath.Ext(base)
 	nameWithoutExt := strings.TrimSuffix(base, ext)
+	if inputFormat == InputFormatFlatOPC {
+		if askYesNo("Сохранить результат как упакованный DOCX (иначе будет сохранен Flat OPC XML)?") {
+			processor.OutputFormat = OutputFormatDocx
+			ext = ".docx"
+		} else {
+			processor.OutputFormat = OutputFormatFlatOPC
+		}
+	}
+	if inputFormat == InputFormatCompound {
+		processor.Password = getPassword("Документ защищен паролем. Введите пароль для открытия (ввод не отображается): ")
+		processor.ReEncrypt = askYesNo("Зашифровать обработанный документ тем же паролем?")
+	}
 	outputDocxProcessedPath := filepath.Join(filepath.Dir(inputDocxPath), fmt.Sprintf("%s_numbered%s", nameWithoutExt, ext))
+	canProcessInPlace := ext == filepath.Ext(base) && (inputFormat != InputFormatCompound || processor.ReEncrypt)
+	if inputFormat == InputFormatCompound && !processor.ReEncrypt {
+		fmt.Println("Результат не будет зашифрован, поэтому он сохраняется в отдельный файл, а не на месте исходного.")
+	}
+	if canProcessInPlace && askYesNo("Обработать файл на месте (заменить исходный файл результатом)?") {
+		outputDocxProcessedPath = inputDocxPath
+		processor.Backup = askBackupMode("\nРезервная копия исходного файла:", processor.Backup)
+	} else if _, err := os.Stat(outputDocxProcessedPath); err == nil {
+		processor.NoClobber = !askYesNo(fmt.Sprintf("Файл '%s' уже существует. Перезаписать его?", outputDocxProcessedPath))
+	}
 
 	fmt.Printf("Файл будет обработан и сохранен как: %s\n", outputDocxProcessedPath)
 
+	if askYesNo("Настроить дополнительные параметры обработки?") {
+		configureProcessor(processor)
+	}
+
 	fmt.Printf("Начинаю обработку файла: %s...\n", inputDocxPath)
-	success, err := AddNumberingToDocx(inputDocxPath, outputDocxProcessedPath)
+	success, err := processor.Process(inputDocxPath, outputDocxProcessedPath)
+	if errors.Is(err, ErrSignedPackage) {
+		fmt.Printf("Файл '%s' не обработан: %v\n", inputDocxPath, err)
+		fmt.Println("Завершение работы.")
+		return
+	}
+	if errors.Is(err, ErrLimitExceeded) {
+		fmt.Printf("Файл '%s' не обработан: %v\n", inputDocxPath, err)
+		fmt.Println("Если файл получен из доверенного источника, ограничения можно изменить или снять в дополнительных параметрах.")
+		fmt.Println("Завершение работы.")
+		return
+	}
+	if errors.Is(err, ErrIntegrity) {
+		fmt.Printf("Файл '%s' не обработан: %v\n", inputDocxPath, err)
+		fmt.Println("Завершение работы.")
+		return
+	}
+	if errors.Is(err, ErrOutputExists) || errors.Is(err, ErrDecryptedInPlace) {
+		fmt.Printf("Файл '%s' не обработан: %v\n", inputDocxPath, err)
+		fmt.Println("Завершение работы.")
+		return
+	}
+	if errors.Is(err, ErrAlreadyProcessed) {
+		fmt.Printf("Файл '%s' пропущен: %v\n", inputDocxPath, err)
+		fmt.Println("Завершение работы.")
+		return
+	}
+	for _, warning := range processor.Warnings {
+		fmt.Printf("Предупреждение: %s\n", warning)
+	}
 	if err != nil {
 		logErrorAndExit(fmt.Sprintf("Ошибка при обработке DOCX файла '%s'", inputDocxPath), err)
 	}
 	if !success {
-		logErrorAndExit(fmt.Sprintf("Не удалось обработать файл '%s'. AddNumberingToDocx вернул false без явной ошибки.", inputDocxPath), nil)
+		logErrorAndExit(fmt.Sprintf("Не удалось обработать файл '%s'. обработчик вернул false без явной ошибки.", inputDocxPath), nil)
 	}
 	fmt.Printf("Файл '%s' успешно обработан и сохранен как '%s'\n", inputDocxPath, outputDocxProcessedPath)
+	if processor.BackupPath != "" {
+		fmt.Printf("Резервная копия исходного файла: %s\n", processor.BackupPath)
+	}
+
+	if processor.ReEncrypt {
+		fmt.Println("Конвертация через Pandoc недоступна для зашифрованных файлов.")
+		fmt.Println("Завершение работы.")
+		return
+	}
+	if strings.ToLower(ext) == ".xml" {
+		fmt.Println("Конвертация через Pandoc доступна только для упакованных DOCX файлов.")
+		fmt.Println("Завершение работы.")
+		return
+	}
 
 	if !askYesNo("Хотите сконвертировать обработанный DOCX файл в другой формат?") {
 		fmt.Println("Завершение работы.")
@@ -136,26 +395,7 @@ func main() {
 		fmt.Println("Формат не может быть пустым.")
 	}
 
-	trackChanges := "all"
-	fmt.Println("\nРежим отслеживания изменений при конвертации:")
-	fmt.Println(" - all (сохранить все изменения и комментарии)")
-	fmt.Println(" - accept (принять все изменения)")
-	fmt.Println(" - reject (отклонить все изменения)")
-	userTrackChanges := getInput(fmt.Sprintf("Введите режим отслеживания изменений (или Enter для '%s'): ", trackChanges))
-	if userTrackChanges != "" {
-		validOptions := []string{"all", "accept", "reject"}
-		isValid := false
-		for _, opt := range validOptions {
-			if strings.ToLower(userTrackChanges) == opt {
-				trackChanges = strings.ToLower(userTrackChanges)
-				isValid = true
-				break
-			}
-		}
-		if !isValid {
-			fmt.Printf("Предупреждение: Введенный режим '%s' не распознан. Будет использован режим '%s'.\n", userTrackChanges, trackChanges)
-		}
-	}
+	trackChanges := askTrackChanges("\nРежим отслеживания изменений при конвертации:", processor.TrackChanges)
 
 	fmt.Printf("\nНачинаю конвертацию файла '%s' в формат '%s'...\n", outputDocxProcessedPath, outputFormat)
 	convertedFilePath, err := ConvertDocxToFormat(outputDocxProcessedPath, outputFormat, trackChanges)
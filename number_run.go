@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/beevik/etree"
+)
+
+var runPropertiesOrder = []string{
+	"rStyle", "rFonts", "b", "bCs", "i", "iCs", "caps", "smallCaps", "strike", "dstrike", "outline", "shadow",
+	"emboss", "imprint", "noProof", "snapToGrid", "vanish", "webHidden", "color", "spacing", "w", "kern",
+	"position", "sz", "szCs", "highlight", "u", "effect", "bdr", "shd", "fitText", "vertAlign", "rtl", "cs",
+	"em", "lang", "eastAsianLayout", "specVanish", "oMath",
+}
+
+var excludedNumberRunProperties = map[string]bool{
+	"ins": true, "del": true, "moveFrom": true, "moveTo": true, "rPrChange": true,
+}
+
+func (dnp *DocxNumberingProcessor) buildNumberRun(paragraph *etree.Element, numPrefix string, level *NumberingLevel, styleSheet *StyleSheet) *etree.Element {
+	rElement := createElement(paragraph, "r", nil)
+
+	bidi := isBidiParagraph(paragraph, styleSheet)
+	if bidi && dnp.DirectionMarks {
+		numPrefix = rightToLeftMark + numPrefix + rightToLeftMark
+	}
+
+	if rPr := dnp.numberRunProperties(paragraph, level, bidi); rPr != nil {
+		rElement.AddChild(rPr)
+	}
+
+	suffix := levelSuffixSpace
+	if level != nil {
+		suffix = level.Suffix
+	}
+
+	tElement := createElement(paragraph, "t", nil)
+	tElement.CreateAttr("xml:space", "preserve")
+	rElement.AddChild(tElement)
+	switch suffix {
+	case levelSuffixNothing:
+		tElement.SetText(numPrefix)
+	case levelSuffixSpace:
+		tElement.SetText(numPrefix + " ")
+	default:
+		tElement.SetText(numPrefix)
+		rElement.AddChild(createElement(paragraph, "tab", nil))
+	}
+	return rElement
+}
+
+func (dnp *DocxNumberingProcessor) numberRunProperties(paragraph *etree.Element, level *NumberingLevel, bidi bool) *etree.Element {
+	properties := make(map[string]*etree.Element)
+
+	if markRPr := normalizeWordElement(findElement(paragraph, "./w:pPr/w:rPr")); markRPr != nil {
+		for _, child := range markRPr.ChildElements() {
+			if !excludedNumberRunProperties[child.Tag] {
+				properties[child.Tag] = child
+			}
+		}
+	}
+	if level != nil && level.RunProperties != nil {
+		for _, child := range level.RunProperties.ChildElements() {
+			properties[child.Tag] = child
+		}
+	}
+	if bidi {
+		if _, exists := properties["rtl"]; !exists {
+			properties["rtl"] = etree.NewElement(wordProcessingMLPrefix + ":rtl")
+		}
+	}
+	if dnp.NumberRunStyle != "" {
+		rStyle := etree.NewElement(wordProcessingMLPrefix + ":rStyle")
+		rStyle.CreateAttr(wordProcessingMLPrefix+":val", dnp.NumberRunStyle)
+		properties["rStyle"] = rStyle
+	}
+
+	rPr := etree.NewElement(wordProcessingMLPrefix + ":rPr")
+	for _, tag := range runPropertiesOrder {
+		if property := properties[tag]; property != nil {
+			rPr.AddChild(property.Copy())
+		}
+	}
+	if len(rPr.ChildElements()) == 0 {
+		return nil
+	}
+	return adoptWordElement(paragraph, rPr)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+const (
+	NumberingCleanupKeep       = "keep"
+	NumberingCleanupUnused     = "unused"
+	NumberingCleanupRemovePart = "remove"
+)
+
+func (dnp *DocxNumberingProcessor) cleanupNumbering(pkg *Package, parts PackageParts, stream *documentStream) error {
+	if dnp.NumberingCleanup == NumberingCleanupKeep || parts.Numbering == "" || !pkg.HasPart(parts.Numbering) {
+		return nil
+	}
+
+	if stream != nil && dnp.NumberingCleanup == NumberingCleanupRemovePart {
+		if _, err := pkg.ReadPart(parts.MainDocument); err != nil {
+			return err
+		}
+	}
+	if stream != nil && !stream.done {
+		return deferNumberingCleanup(pkg, parts, stream)
+	}
+
+	referenced, err := collectNumberingReferences(pkg, numberingReferenceParts(pkg, parts, ""))
+	if err != nil {
+		return err
+	}
+	return dnp.cleanupNumberingPart(pkg, parts.MainDocument, parts.Numbering, referenced)
+}
+
+func (dnp *DocxNumberingProcessor) cleanupGlossaryNumbering(pkg *Package, glossary GlossaryParts) error {
+	if dnp.NumberingCleanup == NumberingCleanupKeep || glossary.Numbering == "" || !pkg.HasPart(glossary.Numbering) {
+		return nil
+	}
+	referenced, err := collectNumberingReferences(pkg, []string{glossary.Document, glossary.Styles})
+	if err != nil {
+		return err
+	}
+	return dnp.cleanupNumberingPart(pkg, glossary.Document, glossary.Numbering, referenced)
+}
+
+func (dnp *DocxNumberingProcessor) cleanupNumberingPart(pkg *Package, sourcePartName, numberingPartName string, referenced map[string]bool) error {
+	content, err := pkg.ReadPart(numberingPartName)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения %s: %w", numberingPartName, err)
+	}
+	doc, err := removeUnreferencedNumbering(content, referenced)
+	if err != nil {
+		return fmt.Errorf("ошибка парсинга %s: %w", numberingPartName, err)
+	}
+
+	if dnp.NumberingCleanup == NumberingCleanupRemovePart && len(findAllElements(doc.Root(), "./w:num")) == 0 {
+		if err := removeNumberingPart(pkg, sourcePartName, numberingPartName); err != nil {
+			return fmt.Errorf("ошибка удаления %s: %w", numberingPartName, err)
+		}
+		return nil
+	}
+
+	output, err := doc.WriteToBytes()
+	if err != nil {
+		return err
+	}
+	pkg.WritePart(numberingPartName, output)
+	return nil
+}
+
+func deferNumberingCleanup(pkg *Package, parts PackageParts, stream *documentStream) error {
+	referenced, err := collectNumberingReferences(pkg, numberingReferenceParts(pkg, parts, parts.MainDocument))
+	if err != nil {
+		return err
+	}
+	return pkg.TransformPartAfter(parts.Numbering, parts.MainDocument, func(r io.Reader, w io.Writer) error {
+		if !stream.done {
+			if _, err := pkg.ReadPart(parts.MainDocument); err != nil {
+				return err
+			}
+		}
+		for numID := range stream.numIDs {
+			referenced[numID] = true
+		}
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		doc, err := removeUnreferencedNumbering(content, referenced)
+		if err != nil {
+			return fmt.Errorf("ошибка парсинга %s: %w", parts.Numbering, err)
+		}
+		_, err = doc.WriteTo(w)
+		return err
+	})
+}
+
+func removeUnreferencedNumbering(content []byte, referenced map[string]bool) (*etree.Document, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(content); err != nil {
+		return nil, err
+	}
+	numberingRoot := doc.Root()
+
+	usedAbstractNums := make(map[string]bool)
+	for _, num := range findAllElements(numberingRoot, "./w:num") {
+		numID, _ := getAttribute(num, "numId")
+		if !referenced[numID] {
+			numberingRoot.RemoveChild(num)
+			continue
+		}
+		if abstractNumIDElement := findElement(num, "./w:abstractNumId"); abstractNumIDElement != nil {
+			if val, ok := getAttribute(abstractNumIDElement, "val"); ok {
+				usedAbstractNums[val] = true
+			}
+		}
+	}
+
+	abstractNums := findAllElements(numberingRoot, "./w:abstractNum")
+	usedStyleLinks := make(map[string]bool)
+	for _, abstractNum := range abstractNums {
+		abstractNumID, _ := getAttribute(abstractNum, "abstractNumId")
+		if !usedAbstractNums[abstractNumID] {
+			continue
+		}
+		if link := findElement(abstractNum, "./w:numStyleLink"); link != nil {
+			if val, ok := getAttribute(link, "val"); ok {
+				usedStyleLinks[val] = true
+			}
+		}
+	}
+	for _, abstractNum := range abstractNums {
+		abstractNumID, _ := getAttribute(abstractNum, "abstractNumId")
+		if usedAbstractNums[abstractNumID] {
+			continue
+		}
+		if link := findElement(abstractNum, "./w:styleLink"); link != nil {
+			if val, ok := getAttribute(link, "val"); ok && usedStyleLinks[val] {
+				continue
+			}
+		}
+		numberingRoot.RemoveChild(abstractNum)
+	}
+
+	return doc, nil
+}
+
+func numberingReferenceParts(pkg *Package, parts PackageParts, skipPartName string) []string {
+	excluded := map[string]bool{parts.Numbering: true, skipPartName: true}
+	for _, glossary := range parts.Glossaries {
+		excluded[glossary.Document] = true
+		excluded[glossary.Numbering] = true
+		excluded[glossary.Styles] = true
+	}
+	var partNames []string
+	for _, partName := range pkg.PartNames() {
+		if !excluded[partName] && strings.HasSuffix(strings.ToLower(partName), ".xml") {
+			partNames = append(partNames, partName)
+		}
+	}
+	return partNames
+}
+
+func collectNumberingReferences(pkg *Package, partNames []string) (map[string]bool, error) {
+	referenced := make(map[string]bool)
+	for _, partName := range partNames {
+		if partName == "" || !pkg.HasPart(partName) {
+			continue
+		}
+		content, err := pkg.ReadPart(partName)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Contains(content, []byte("numId")) {
+			continue
+		}
+		doc := etree.NewDocument()
+		if err := doc.ReadFromBytes(content); err != nil || doc.Root() == nil {
+			continue
+		}
+		for _, numIDElement := range findAllElements(doc.Root(), "//w:numId") {
+			if val, ok := getAttribute(numIDElement, "val"); ok && val != "0" {
+				referenced[val] = true
+			}
+		}
+	}
+	return referenced, nil
+}
+
+func removeNumberingPart(pkg *Package, sourcePartName, numberingPartName string) error {
+	rels, err := readPartRelationships(pkg, sourcePartName)
+	if err != nil {
+		return err
+	}
+	for _, rel := range rels.ByType(relTypeNumbering) {
+		if rel.TargetMode != targetModeExternal && resolveRelationshipTarget(sourcePartName, rel.Target) == numberingPartName {
+			rels.Remove(rel.ID)
+		}
+	}
+	if err := writePartRelationships(pkg, sourcePartName, rels); err != nil {
+		return err
+	}
+
+	pkg.RemovePart(numberingPartName)
+	pkg.RemovePart(relationshipsPartName(numberingPartName))
+
+	contentTypes, err := readContentTypes(pkg)
+	if err != nil {
+		return err
+	}
+	contentTypes.RemoveOverride(numberingPartName)
+	return writeContentTypes(pkg, contentTypes)
+}
@@ -48,17 +48,7 @@ func (nd *NumberingDefinition) GetFormattedNumber(levelID string) string {
 
 	text := level.TextTemplate
 
-	var levelIDs []string
-	for id := range nd.Levels {
-		levelIDs = append(levelIDs, id)
-	}
-	sort.Slice(levelIDs, func(i, j int) bool {
-		id1, _ := strconv.Atoi(levelIDs[i])
-		id2, _ := strconv.Atoi(levelIDs[j])
-		return id1 < id2
-	})
-
-	for _, subLevelIDStr := range levelIDs {
+	for _, subLevelIDStr := range nd.SortedLevelIDs() {
 		subLevel, exists := nd.Levels[subLevelIDStr]
 		if !exists {
 			continue
@@ -72,3 +62,16 @@ func (nd *NumberingDefinition) GetFormattedNumber(levelID string) string {
 	}
 	return text
 }
+
+func (nd *NumberingDefinition) SortedLevelIDs() []string {
+	var levelIDs []string
+	for id := range nd.Levels {
+		levelIDs = append(levelIDs, id)
+	}
+	sort.Slice(levelIDs, func(i, j int) bool {
+		id1, _ := strconv.Atoi(levelIDs[i])
+		id2, _ := strconv.Atoi(levelIDs[j])
+		return id1 < id2
+	})
+	return levelIDs
+}
@@ -26,11 +26,11 @@ func formatNumber(number int, formatType string) string {
 		return strings.ToLower(toRoman(number))
 	case "upperLetter":
 		if number >= 1 && number <= 26 {
-			return string('A' + number - 1)
+			return string(rune('A' + number - 1))
 		}
 	case "lowerLetter":
 		if number >= 1 && number <= 26 {
-			return string('a' + number - 1)
+			return string(rune('a' + number - 1))
 		}
 	}
 	return fmt.Sprintf("%d", number)
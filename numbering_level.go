@@ -1,10 +1,23 @@
 package main
 
+import "github.com/beevik/etree"
+
+const (
+	levelSuffixTab     = "tab"
+	levelSuffixSpace   = "space"
+	levelSuffixNothing = "nothing"
+)
+
 type NumberingLevel struct {
-	FormatType   string
-	TextTemplate string
-	StartValue   int
-	CurrentValue int
+	FormatType          string
+	TextTemplate        string
+	StartValue          int
+	CurrentValue        int
+	Suffix              string
+	Justification       string
+	StyleID             string
+	RunProperties       *etree.Element
+	ParagraphProperties *etree.Element
 }
 
 func NewNumberingLevel(formatType, textTemplate string, startValue int) *NumberingLevel {
@@ -13,6 +26,7 @@ func NewNumberingLevel(formatType, textTemplate string, startValue int) *Numberi
 		TextTemplate: textTemplate,
 		StartValue:   startValue,
 		CurrentValue: startValue,
+		Suffix:       levelSuffixTab,
 	}
 }
 
@@ -2,14 +2,20 @@ package main
 
 import (
 	"strconv"
+	"strings"
 
 	"github.com/beevik/etree"
 )
 
 type AbstractLvlData struct {
-	Format string
-	Text   string
-	Start  int
+	Format              string
+	Text                string
+	Start               int
+	Suffix              string
+	Justification       string
+	StyleID             string
+	RunProperties       *etree.Element
+	ParagraphProperties *etree.Element
 }
 
 type NumberingParser struct {
@@ -51,36 +57,61 @@ func (np *NumberingParser) parseAbstractNumbering(numberingRoot *etree.Element)
 				continue
 			}
 
-			numFmt := "decimal"
+			lvlData := parseLevelData(lvl)
+			lvlData.Format = "decimal"
 			if numFmtElement := findElement(lvl, ".//w:numFmt"); numFmtElement != nil {
 				if val, okVal := getAttribute(numFmtElement, "val"); okVal && val != "" {
-					numFmt = val
+					lvlData.Format = val
 				}
 			}
+			np.AbstractNumberingData[abstractNumID][ilvl] = lvlData
+		}
+	}
+}
 
-			lvlText := "%1."
-			if lvlTextElement := findElement(lvl, ".//w:lvlText"); lvlTextElement != nil {
-				if val, okVal := getAttribute(lvlTextElement, "val"); okVal && val != "" {
-					lvlText = val
-				}
-			}
+func parseLevelData(lvl *etree.Element) AbstractLvlData {
+	lvlText := "%1."
+	if lvlTextElement := findElement(lvl, ".//w:lvlText"); lvlTextElement != nil {
+		if val, okVal := getAttribute(lvlTextElement, "val"); okVal && val != "" {
+			lvlText = val
+		}
+	}
 
-			start := 1
-			if startElement := findElement(lvl, ".//w:start"); startElement != nil {
-				if startStr, okVal := getAttribute(startElement, "val"); okVal && startStr != "" {
-					if s, err := strconv.Atoi(startStr); err == nil {
-						start = s
-					}
-				}
+	start := 1
+	if startElement := findElement(lvl, ".//w:start"); startElement != nil {
+		if startStr, okVal := getAttribute(startElement, "val"); okVal && startStr != "" {
+			if s, err := strconv.Atoi(startStr); err == nil {
+				start = s
 			}
+		}
+	}
 
-			np.AbstractNumberingData[abstractNumID][ilvl] = AbstractLvlData{
-				Format: numFmt,
-				Text:   lvlText,
-				Start:  start,
-			}
+	suffix := levelSuffixTab
+	if suffElement := findElement(lvl, "./w:suff"); suffElement != nil {
+		if val, okVal := getAttribute(suffElement, "val"); okVal && val != "" {
+			suffix = strings.ToLower(val)
 		}
 	}
+
+	justification := ""
+	if lvlJcElement := findElement(lvl, "./w:lvlJc"); lvlJcElement != nil {
+		justification, _ = getAttribute(lvlJcElement, "val")
+	}
+
+	styleID := ""
+	if pStyleElement := findElement(lvl, "./w:pStyle"); pStyleElement != nil {
+		styleID, _ = getAttribute(pStyleElement, "val")
+	}
+
+	return AbstractLvlData{
+		Text:                lvlText,
+		Start:               start,
+		Suffix:              suffix,
+		Justification:       justification,
+		StyleID:             styleID,
+		RunProperties:       normalizeWordElement(findElement(lvl, "./w:rPr")),
+		ParagraphProperties: normalizeWordElement(findElement(lvl, "./w:pPr")),
+	}
 }
 
 func (np *NumberingParser) parseNumbering(numberingRoot *etree.Element) {
@@ -97,41 +128,52 @@ func (np *NumberingParser) parseNumbering(numberingRoot *etree.Element) {
 			}
 		}
 
-		if abstractNumIDVal == "" {
-			continue
-		}
-		abstractData, dataExists := np.AbstractNumberingData[abstractNumIDVal]
-		if !dataExists {
-			continue
+		if numDef := np.buildNumberingDefinition(abstractNumIDVal, num); numDef != nil {
+			np.NumberingDefinitions[numID] = numDef
 		}
+	}
+}
 
-		numDef := NewNumberingDefinition(abstractNumIDVal)
+func (np *NumberingParser) buildNumberingDefinition(abstractNumID string, num *etree.Element) *NumberingDefinition {
+	if abstractNumID == "" {
+		return nil
+	}
+	abstractData, dataExists := np.AbstractNumberingData[abstractNumID]
+	if !dataExists {
+		return nil
+	}
 
-		for lvlID, lvlData := range abstractData {
-			level := NewNumberingLevel(lvlData.Format, lvlData.Text, lvlData.Start)
-			numDef.AddLevel(lvlID, level)
-		}
+	numDef := NewNumberingDefinition(abstractNumID)
 
-		for _, lvlOverride := range findAllElements(num, ".//w:lvlOverride") {
-			ilvl, okLvl := getAttribute(lvlOverride, "ilvl")
-			if !okLvl || ilvl == "" {
-				continue
-			}
+	for lvlID, lvlData := range abstractData {
+		level := NewNumberingLevel(lvlData.Format, lvlData.Text, lvlData.Start)
+		level.Suffix = lvlData.Suffix
+		level.Justification = lvlData.Justification
+		level.StyleID = lvlData.StyleID
+		level.RunProperties = lvlData.RunProperties
+		level.ParagraphProperties = lvlData.ParagraphProperties
+		numDef.AddLevel(lvlID, level)
+	}
 
-			levelToOverride, levelExists := numDef.Levels[ilvl]
-			if !levelExists {
-				continue
-			}
+	for _, lvlOverride := range findAllElements(num, ".//w:lvlOverride") {
+		ilvl, okLvl := getAttribute(lvlOverride, "ilvl")
+		if !okLvl || ilvl == "" {
+			continue
+		}
+
+		levelToOverride, levelExists := numDef.Levels[ilvl]
+		if !levelExists {
+			continue
+		}
 
-			if startOverrideElement := findElement(lvlOverride, ".//w:startOverride"); startOverrideElement != nil {
-				if newStartStr, okVal := getAttribute(startOverrideElement, "val"); okVal && newStartStr != "" {
-					if newStart, err := strconv.Atoi(newStartStr); err == nil {
-						levelToOverride.StartValue = newStart
-						levelToOverride.CurrentValue = newStart
-					}
+		if startOverrideElement := findElement(lvlOverride, ".//w:startOverride"); startOverrideElement != nil {
+			if newStartStr, okVal := getAttribute(startOverrideElement, "val"); okVal && newStartStr != "" {
+				if newStart, err := strconv.Atoi(newStartStr); err == nil {
+					levelToOverride.StartValue = newStart
+					levelToOverride.CurrentValue = newStart
 				}
 			}
 		}
-		np.NumberingDefinitions[numID] = numDef
 	}
+	return numDef
 }
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+const packageFileMode = 0644
+
+var packageEpoch = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type packageEntry struct {
+	name      string
+	file      *zip.File
+	data      []byte
+	loaded    bool
+	modified  bool
+	removed   bool
+	transform func(io.Reader, io.Writer) error
+	after     string
+}
+
+type Package struct {
+	entries []*packageEntry
+	byName  map[string]*packageEntry
+	limiter *packageLimiter
+}
+
+func NewPackage() *Package {
+	return &Package{byName: make(map[string]*packageEntry)}
+}
+
+func OpenPackage(r io.ReaderAt, size int64, limiter *packageLimiter) (*Package, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if err := limiter.checkEntries(len(zr.File)); err != nil {
+		return nil, err
+	}
+	pkg := NewPackage()
+	pkg.limiter = limiter
+	for _, f := range zr.File {
+		name := f.Name
+		if !isValidPartName(strings.TrimSuffix(name, "/")) {
+			return nil, fmt.Errorf("недопустимый путь к файлу: %s", name)
+		}
+		if existing, ok := pkg.byName[name]; ok {
+			existing.removed = true
+		}
+		entry := &packageEntry{name: name, file: f}
+		pkg.entries = append(pkg.entries, entry)
+		pkg.byName[name] = entry
+	}
+	return pkg, nil
+}
+
+func isValidPartName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") || strings.Contains(name, "\\") {
+		return false
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func isXMLPartName(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.HasSuffix(lower, ".xml") || strings.HasSuffix(lower, ".rels")
+}
+
+func (p *Package) entry(name string) *packageEntry {
+	entry, ok := p.byName[name]
+	if !ok || entry.removed || strings.HasSuffix(entry.name, "/") {
+		return nil
+	}
+	return entry
+}
+
+func (p *Package) HasPart(name string) bool {
+	return p.entry(name) != nil
+}
+
+func (p *Package) ReadPart(name string) ([]byte, error) {
+	entry := p.entry(name)
+	if entry == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if entry.transform != nil {
+		var output bytes.Buffer
+		if err := p.writeTransformed(&output, entry); err != nil {
+			return nil, err
+		}
+		entry.data, entry.loaded, entry.transform, entry.after = output.Bytes(), true, nil, ""
+	}
+	if !entry.loaded {
+		rc, err := entry.file.Open()
+		if err != nil {
+			return nil, err
+		}
+		reader := p.limiter.reader(rc, name, int64(entry.file.CompressedSize64))
+		var data []byte
+		if isXMLPartName(name) {
+			data, err = p.limiter.readXML(reader, name)
+		} else {
+			data, err = io.ReadAll(reader)
+		}
+		rc.Close()
+		var limitErr *LimitError
+		if errors.As(err, &limitErr) {
+			return nil, err
+		} else if err != nil {
+			return nil, fmt.Errorf("ошибка чтения %s: %w", name, err)
+		}
+		entry.data = data
+		entry.loaded = true
+	}
+	return entry.data, nil
+}
+
+func (p *Package) OpenPart(name string) (io.ReadCloser, error) {
+	entry := p.entry(name)
+	if entry == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if entry.transform != nil {
+		data, err := p.ReadPart(name)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	return p.openSource(entry, p.limiter.reader)
+}
+
+func (p *Package) PeekPart(name string) (io.ReadCloser, error) {
+	entry := p.entry(name)
+	if entry == nil || entry.transform != nil {
+		return p.OpenPart(name)
+	}
+	return p.openSource(entry, p.limiter.peekReader)
+}
+
+func (p *Package) openSource(entry *packageEntry, limit func(io.Reader, string, int64) io.Reader) (io.ReadCloser, error) {
+	if entry.loaded {
+		return io.NopCloser(bytes.NewReader(entry.data)), nil
+	}
+	rc, err := entry.file.Open()
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{limit(rc, entry.name, int64(entry.file.CompressedSize64)), rc}, nil
+}
+
+func (p *Package) TransformPart(name string, transform func(io.Reader, io.Writer) error) error {
+	return p.TransformPartAfter(name, "", transform)
+}
+
+func (p *Package) TransformPartAfter(name, after string, transform func(io.Reader, io.Writer) error) error {
+	entry := p.entry(name)
+	if entry == nil {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if entry.transform != nil {
+		if _, err := p.ReadPart(name); err != nil {
+			return err
+		}
+	}
+	entry.transform, entry.after = transform, after
+	entry.modified = true
+	return nil
+}
+
+func (p *Package) writeTransformed(w io.Writer, entry *packageEntry) error {
+	source, err := p.openSource(entry, p.limiter.reader)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	return entry.transform(source, w)
+}
+
+func (p *Package) WritePart(name string, data []byte) {
+	if entry := p.entry(name); entry != nil {
+		entry.data = data
+		entry.loaded = true
+		entry.modified = true
+		entry.transform, entry.after = nil, ""
+		return
+	}
+	entry := &packageEntry{name: name, data: data, loaded: true, modified: true}
+	p.entries = append(p.entries, entry)
+	p.byName[name] = entry
+}
+
+func (p *Package) RemovePart(name string) {
+	if entry := p.entry(name); entry != nil {
+		entry.removed = true
+	}
+}
+
+func (p *Package) PartNames() []string {
+	var names []string
+	for _, entry := range p.entries {
+		if !entry.removed && !strings.HasSuffix(entry.name, "/") {
+			names = append(names, entry.name)
+		}
+	}
+	return names
+}
+
+func (p *Package) LatestModified() time.Time {
+	latest := packageEpoch
+	for _, entry := range p.entries {
+		if entry.file != nil && entry.file.Modified.After(latest) {
+			latest = entry.file.Modified
+		}
+	}
+	return latest
+}
+
+func (p *Package) Save(w io.Writer) error {
+	zipWriter := zip.NewWriter(w)
+
+	ordered := make([]*packageEntry, 0, len(p.entries))
+	if contentTypes := p.entry(contentTypesPartName); contentTypes != nil {
+		ordered = append(ordered, contentTypes)
+	}
+	for _, entry := range p.entries {
+		if !entry.removed && entry.name != contentTypesPartName {
+			ordered = append(ordered, entry)
+		}
+	}
+
+	for _, entry := range p.orderTransforms(ordered) {
+		if strings.HasSuffix(entry.name, "/") && !p.hasPartsUnder(entry.name) {
+			continue
+		}
+		if err := p.writeEntry(zipWriter, entry); err != nil {
+			return fmt.Errorf("ошибка записи %s: %w", entry.name, err)
+		}
+	}
+	return zipWriter.Close()
+}
+
+func (p *Package) orderTransforms(entries []*packageEntry) []*packageEntry {
+	ordered := make([]*packageEntry, 0, len(entries))
+	placed := make(map[string]bool)
+	waiting := make(map[string][]*packageEntry)
+	var place func(entry *packageEntry)
+	place = func(entry *packageEntry) {
+		ordered = append(ordered, entry)
+		placed[entry.name] = true
+		for _, next := range waiting[entry.name] {
+			place(next)
+		}
+		delete(waiting, entry.name)
+	}
+	for _, entry := range entries {
+		if entry.after != "" && !placed[entry.after] && p.entry(entry.after) != nil {
+			waiting[entry.after] = append(waiting[entry.after], entry)
+			continue
+		}
+		place(entry)
+	}
+	return ordered
+}
+
+func (p *Package) hasPartsUnder(dir string) bool {
+	for _, name := range p.PartNames() {
+		if strings.HasPrefix(name, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Package) writeEntry(zipWriter *zip.Writer, entry *packageEntry) error {
+	if !entry.modified && entry.file != nil {
+		header := entry.file.FileHeader
+		writer, err := zipWriter.CreateRaw(&header)
+		if err != nil {
+			return err
+		}
+		raw, err := entry.file.OpenRaw()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(writer, raw)
+		return err
+	}
+
+	header := &zip.FileHeader{
+		Name:     entry.name,
+		Method:   zip.Deflate,
+		Modified: packageEpoch,
+	}
+	header.SetMode(packageFileMode)
+	if entry.file != nil {
+		header.Method = entry.file.Method
+		header.Modified = entry.file.Modified
+		header.Comment = entry.file.Comment
+	}
+	writer, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	if entry.transform != nil {
+		return p.writeTransformed(writer, entry)
+	}
+	_, err = writer.Write(entry.data)
+	return err
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	relTypeOfficeDocument       = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument"
+	relTypeStyles               = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles"
+	relTypePackage              = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/package"
+	relTypeHeader               = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/header"
+	relTypeFooter               = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/footer"
+	relTypeFootnotes            = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/footnotes"
+	relTypeEndnotes             = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/endnotes"
+	relTypeComments             = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/comments"
+	defaultMainDocumentPartName = "word/document.xml"
+)
+
+var storyRelTypes = []string{relTypeHeader, relTypeFooter, relTypeFootnotes, relTypeEndnotes, relTypeComments}
+
+type PackageParts struct {
+	MainDocument string
+	Numbering    string
+	Styles       string
+	Stories      []string
+	Glossaries   []GlossaryParts
+}
+
+type GlossaryParts struct {
+	Document  string
+	Numbering string
+	Styles    string
+}
+
+func locatePackageParts(pkg *Package) (PackageParts, error) {
+	var parts PackageParts
+
+	packageRels, err := readPartRelationships(pkg, "")
+	if err != nil {
+		return parts, err
+	}
+	for _, rel := range packageRels.ByType(relTypeOfficeDocument) {
+		if rel.TargetMode != targetModeExternal {
+			if parts.MainDocument = resolveRelationshipTarget("", rel.Target); parts.MainDocument != "" {
+				break
+			}
+		}
+	}
+
+	if parts.MainDocument == "" {
+		if content, err := pkg.ReadPart(contentTypesPartName); err == nil {
+			if contentTypes, err := ParseContentTypes(content); err == nil {
+				parts.MainDocument = contentTypes.PartNameForContentType(wordMainContentTypes)
+			}
+		}
+	}
+	if parts.MainDocument == "" {
+		parts.MainDocument = defaultMainDocumentPartName
+	}
+
+	documentRels, err := readPartRelationships(pkg, parts.MainDocument)
+	if err != nil {
+		return parts, err
+	}
+	for _, rel := range documentRels.All() {
+		if rel.TargetMode == targetModeExternal {
+			continue
+		}
+		switch {
+		case isRelationshipType(rel.Type, relTypeNumbering) && parts.Numbering == "":
+			parts.Numbering = resolveRelationshipTarget(parts.MainDocument, rel.Target)
+		case isRelationshipType(rel.Type, relTypeStyles) && parts.Styles == "":
+			parts.Styles = resolveRelationshipTarget(parts.MainDocument, rel.Target)
+		case isStoryRelationship(rel.Type):
+			if partName := resolveRelationshipTarget(parts.MainDocument, rel.Target); partName != "" && !slices.Contains(parts.Stories, partName) {
+				parts.Stories = append(parts.Stories, partName)
+			}
+		case isRelationshipType(rel.Type, relTypeGlossary):
+			glossaryPartName := resolveRelationshipTarget(parts.MainDocument, rel.Target)
+			if glossaryPartName == "" || !pkg.HasPart(glossaryPartName) {
+				continue
+			}
+			glossaryRels, err := readPartRelationships(pkg, glossaryPartName)
+			if err != nil {
+				return parts, err
+			}
+			parts.Glossaries = append(parts.Glossaries, GlossaryParts{
+				Document:  glossaryPartName,
+				Numbering: relatedPartName(glossaryPartName, glossaryRels, relTypeNumbering),
+				Styles:    relatedPartName(glossaryPartName, glossaryRels, relTypeStyles),
+			})
+		}
+	}
+	return parts, nil
+}
+
+func isStoryRelationship(relType string) bool {
+	for _, storyRelType := range storyRelTypes {
+		if isRelationshipType(relType, storyRelType) {
+			return true
+		}
+	}
+	return false
+}
+
+func relatedPartName(sourcePartName string, rels *Relationships, relType string) string {
+	for _, rel := range rels.ByType(relType) {
+		if rel.TargetMode == targetModeExternal {
+			continue
+		}
+		if partName := resolveRelationshipTarget(sourcePartName, rel.Target); partName != "" {
+			return partName
+		}
+	}
+	return ""
+}
+
+func findRelatedParts(pkg *Package, relType string) ([]string, error) {
+	seen := make(map[string]bool)
+	for _, relsPartName := range pkg.PartNames() {
+		if !strings.HasSuffix(relsPartName, ".rels") || path.Base(path.Dir(relsPartName)) != "_rels" {
+			continue
+		}
+		sourcePartName := strings.TrimSuffix(path.Base(relsPartName), ".rels")
+		if sourceDir := path.Dir(path.Dir(relsPartName)); sourceDir != "." {
+			sourcePartName = path.Join(sourceDir, sourcePartName)
+		}
+
+		rels, err := readPartRelationships(pkg, sourcePartName)
+		if err != nil {
+			return nil, err
+		}
+		for _, rel := range rels.ByType(relType) {
+			if partName := resolveRelationshipTarget(sourcePartName, rel.Target); rel.TargetMode != targetModeExternal && partName != "" {
+				seen[partName] = true
+			}
+		}
+	}
+
+	var partNames []string
+	for partName := range seen {
+		partNames = append(partNames, partName)
+	}
+	sort.Strings(partNames)
+	return partNames, nil
+}
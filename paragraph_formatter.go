@@ -9,6 +9,7 @@ import (
 type ParagraphFormatter struct {
 	NumberingDefinitions map[string]*NumberingDefinition
 	LastActiveLevels     map[string]string
+	StyleSheet           *StyleSheet
 }
 
 func NewParagraphFormatter(numberingDefs map[string]*NumberingDefinition) *ParagraphFormatter {
@@ -19,14 +20,59 @@ func NewParagraphFormatter(numberingDefs map[string]*NumberingDefinition) *Parag
 }
 
 func (pf *ParagraphFormatter) FormatParagraph(paragraph *etree.Element) string {
-	pPr := findElement(paragraph, ".//w:pPr")
-	if pPr == nil {
+	if findElement(paragraph, "./w:pPr") == nil {
 		return ""
 	}
 
-	numPr := findElement(pPr, ".//w:numPr")
-	ilvl, numID, found := parseNumberingInfo(numPr)
+	ilvl, numID, found := pf.NumberingInfo(paragraph)
+	return pf.FormatNumbering(ilvl, numID, found)
+}
+
+func (pf *ParagraphFormatter) NumberingInfo(paragraph *etree.Element) (ilvl string, numID string, found bool) {
+	if numPr := findElement(paragraph, "./w:pPr/w:numPr"); numPr != nil {
+		numID, ilvl = numberingPropertyValues(numPr)
+	}
+
+	styleID := ""
+	if pStyleElement := findElement(paragraph, "./w:pPr/w:pStyle"); pStyleElement != nil {
+		styleID, _ = getAttribute(pStyleElement, "val")
+	}
+	if styleID != "" && pf.StyleSheet != nil {
+		styleNumID, styleIlvl := pf.StyleSheet.StyleNumbering(styleID)
+		if numID == "" {
+			numID = styleNumID
+		}
+		if ilvl == "" && numID == styleNumID {
+			ilvl = styleIlvl
+		}
+		if ilvl == "" {
+			ilvl = pf.styleLevel(numID, styleID)
+		}
+	}
+
+	if numID == "" || numID == "0" {
+		return "", numID, false
+	}
+	if ilvl == "" {
+		ilvl = "0"
+	}
+	return ilvl, numID, true
+}
 
+func (pf *ParagraphFormatter) styleLevel(numID, styleID string) string {
+	numDef, ok := pf.NumberingDefinitions[numID]
+	if !ok {
+		return ""
+	}
+	for _, ilvl := range numDef.SortedLevelIDs() {
+		if numDef.Levels[ilvl].StyleID == styleID {
+			return ilvl
+		}
+	}
+	return ""
+}
+
+func (pf *ParagraphFormatter) FormatNumbering(ilvl, numID string, found bool) string {
 	numPrefix := ""
 	if found && pf.hasValidNumbering(ilvl, numID) {
 		numDef := pf.NumberingDefinitions[numID]
@@ -58,6 +104,21 @@ func (pf *ParagraphFormatter) FormatParagraph(paragraph *etree.Element) string {
 	return numPrefix
 }
 
+func (pf *ParagraphFormatter) ParagraphLevel(paragraph *etree.Element) *NumberingLevel {
+	ilvl, numID, found := pf.NumberingInfo(paragraph)
+	if !found {
+		return nil
+	}
+	return pf.NumberingLevel(ilvl, numID)
+}
+
+func (pf *ParagraphFormatter) NumberingLevel(ilvl, numID string) *NumberingLevel {
+	if !pf.hasValidNumbering(ilvl, numID) {
+		return nil
+	}
+	return pf.NumberingDefinitions[numID].Levels[ilvl]
+}
+
 func (pf *ParagraphFormatter) hasValidNumbering(ilvl, numID string) bool {
 	if ilvl == "" || numID == "" {
 		return false
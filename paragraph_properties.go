@@ -0,0 +1,110 @@
+package main
+
+import (
+	"github.com/beevik/etree"
+)
+
+var paragraphPropertiesOrder = []string{
+	"pStyle", "keepNext", "keepLines", "pageBreakBefore", "framePr", "widowControl", "numPr",
+	"suppressLineNumbers", "pBdr", "shd", "tabs", "suppressAutoHyphens", "kinsoku", "wordWrap",
+	"overflowPunct", "topLinePunct", "autoSpaceDE", "autoSpaceDN", "bidi", "adjustRightInd", "snapToGrid",
+	"spacing", "ind", "contextualSpacing", "mirrorIndents", "suppressOverlap", "jc", "textDirection",
+	"textAlignment", "textboxTightWrap", "outlineLvl", "divId", "cnfStyle", "rPr", "sectPr", "pPrChange",
+}
+
+var inheritedLevelProperties = []string{"tabs", "ind", "jc"}
+
+var indentationAlternatives = map[string][]string{
+	"left":      {"start"},
+	"start":     {"left"},
+	"right":     {"end"},
+	"end":       {"right"},
+	"hanging":   {"firstLine", "hangingChars", "firstLineChars"},
+	"firstLine": {"hanging", "hangingChars", "firstLineChars"},
+}
+
+func applyLevelParagraphProperties(paragraph *etree.Element, level *NumberingLevel) {
+	if level == nil || level.ParagraphProperties == nil {
+		return
+	}
+
+	pPr := findElement(paragraph, "./w:pPr")
+	if pPr == nil {
+		pPr = createElement(paragraph, "pPr", nil)
+		paragraph.InsertChildAt(0, pPr)
+	}
+
+	for _, tag := range inheritedLevelProperties {
+		levelProperty := findElement(level.ParagraphProperties, "./w:"+tag)
+		if levelProperty == nil {
+			continue
+		}
+		existing := findElement(pPr, "./w:"+tag)
+		if existing == nil {
+			existing = adoptWordElement(pPr, levelProperty)
+			insertParagraphProperty(pPr, existing)
+		} else if tag == "tabs" {
+			mergeTabStops(existing, levelProperty)
+		} else if tag == "ind" {
+			mergeIndentation(existing, levelProperty)
+		}
+
+		if tag == "tabs" {
+			for _, tab := range findAllElements(existing, "./w:tab[@w:val='num']") {
+				setAttribute(tab, "val", "left")
+			}
+		}
+	}
+}
+
+func insertParagraphProperty(pPr, property *etree.Element) {
+	rank := propertyRank(property.Tag)
+	for _, child := range pPr.ChildElements() {
+		if propertyRank(child.Tag) > rank {
+			pPr.InsertChildAt(child.Index(), property)
+			return
+		}
+	}
+	pPr.AddChild(property)
+}
+
+func propertyRank(tag string) int {
+	for i, candidate := range paragraphPropertiesOrder {
+		if candidate == tag {
+			return i
+		}
+	}
+	return len(paragraphPropertiesOrder)
+}
+
+func mergeTabStops(tabs, levelTabs *etree.Element) {
+	positions := make(map[string]bool)
+	for _, tab := range findAllElements(tabs, "./w:tab") {
+		if pos, ok := getAttribute(tab, "pos"); ok {
+			positions[pos] = true
+		}
+	}
+	for _, tab := range findAllElements(levelTabs, "./w:tab") {
+		if pos, ok := getAttribute(tab, "pos"); ok && !positions[pos] {
+			tabs.AddChild(adoptWordElement(tabs, tab))
+		}
+	}
+}
+
+func mergeIndentation(ind, levelInd *etree.Element) {
+	for _, attr := range levelInd.Attr {
+		if _, ok := getAttribute(ind, attr.Key); ok {
+			continue
+		}
+		overridden := false
+		for _, alternative := range indentationAlternatives[attr.Key] {
+			if _, ok := getAttribute(ind, alternative); ok {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			setAttribute(ind, attr.Key, attr.Value)
+		}
+	}
+}
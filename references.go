@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/beevik/etree"
+)
+
+const (
+	positionAbove = "above"
+	positionBelow = "below"
+)
+
+type NumberComponent struct {
+	Value          int
+	Text           string
+	IncludesParent bool
+	Placeholder    bool
+}
+
+type ParagraphNumber struct {
+	NumID  string
+	Levels []NumberComponent
+}
+
+func (pn *ParagraphNumber) NoContext() string {
+	return strings.TrimSuffix(pn.Levels[len(pn.Levels)-1].Text, ".")
+}
+
+func (pn *ParagraphNumber) FullContext() string {
+	return pn.contextFrom(0)
+}
+
+func (pn *ParagraphNumber) RelativeContext(from *ParagraphNumber) string {
+	if from == nil || from.NumID != pn.NumID {
+		return pn.FullContext()
+	}
+	common := 0
+	for common < len(pn.Levels)-1 && common < len(from.Levels) && pn.Levels[common].Value == from.Levels[common].Value {
+		common++
+	}
+	return pn.contextFrom(common)
+}
+
+func (pn *ParagraphNumber) contextFrom(start int) string {
+	text := ""
+	for i := start; i < len(pn.Levels); i++ {
+		if pn.Levels[i].Placeholder {
+			continue
+		}
+		component := pn.Levels[i].Text
+		if pn.Levels[i].IncludesParent {
+			text = component
+			continue
+		}
+		if first, _ := utf8.DecodeRuneInString(component); text != "" && !unicode.IsLetter(first) && !unicode.IsDigit(first) {
+			text = strings.TrimSuffix(text, ".")
+		}
+		text += component
+	}
+	return strings.TrimSuffix(text, ".")
+}
+
+func (pf *ParagraphFormatter) NumberSnapshot(ilvl, numID string) *ParagraphNumber {
+	if !pf.hasValidNumbering(ilvl, numID) {
+		return nil
+	}
+	numDef := pf.NumberingDefinitions[numID]
+	currentLevel, err := strconv.Atoi(ilvl)
+	if err != nil {
+		return nil
+	}
+
+	snapshot := &ParagraphNumber{NumID: numID}
+	for i := 0; i <= currentLevel; i++ {
+		level, ok := numDef.Levels[strconv.Itoa(i)]
+		if !ok {
+			snapshot.Levels = append(snapshot.Levels, NumberComponent{Placeholder: true})
+			continue
+		}
+		snapshot.Levels = append(snapshot.Levels, NumberComponent{
+			Value:          level.CurrentValue,
+			Text:           numDef.GetFormattedNumber(strconv.Itoa(i)),
+			IncludesParent: i > 0 && strings.Contains(level.TextTemplate, fmt.Sprintf("%%%d", i)),
+		})
+	}
+	if len(snapshot.Levels) == 0 {
+		return nil
+	}
+	return snapshot
+}
+
+type noteReference struct {
+	Endnote bool
+	Index   int
+}
+
+type referenceIndex struct {
+	bookmarks      map[string]*etree.Element
+	notes          map[string]noteReference
+	paragraphOrder map[*etree.Element]int
+	footnoteFormat string
+	endnoteFormat  string
+}
+
+func buildReferenceIndex(root *etree.Element) *referenceIndex {
+	index := &referenceIndex{
+		bookmarks:      make(map[string]*etree.Element),
+		notes:          make(map[string]noteReference),
+		paragraphOrder: make(map[*etree.Element]int),
+		footnoteFormat: "decimal",
+		endnoteFormat:  "lowerRoman",
+	}
+
+	for i, paragraph := range findAllElements(root, "//w:p") {
+		index.paragraphOrder[paragraph] = i
+	}
+	if numFmt := findElement(root, "//w:sectPr/w:footnotePr/w:numFmt"); numFmt != nil {
+		if val, ok := getAttribute(numFmt, "val"); ok && val != "" {
+			index.footnoteFormat = val
+		}
+	}
+	if numFmt := findElement(root, "//w:sectPr/w:endnotePr/w:numFmt"); numFmt != nil {
+		if val, ok := getAttribute(numFmt, "val"); ok && val != "" {
+			index.endnoteFormat = val
+		}
+	}
+
+	var pending []string
+	footnotes, endnotes := 0, 0
+	for _, element := range findAllElements(root, "//w:*") {
+		switch {
+		case isWordElement(element, "bookmarkStart"):
+			name, ok := getAttribute(element, "name")
+			if !ok || name == "" {
+				continue
+			}
+			if paragraph := bookmarkParagraph(element); paragraph != nil {
+				index.bookmarks[name] = paragraph
+			}
+			pending = append(pending, name)
+		case isWordElement(element, "footnoteReference"), isWordElement(element, "endnoteReference"):
+			if customMark, ok := getAttribute(element, "customMarkFollows"); ok && isOnOffValue(customMark) {
+				continue
+			}
+			note := noteReference{Endnote: isWordElement(element, "endnoteReference")}
+			if note.Endnote {
+				endnotes++
+				note.Index = endnotes
+			} else {
+				footnotes++
+				note.Index = footnotes
+			}
+			for _, name := range pending {
+				index.notes[name] = note
+			}
+			pending = nil
+		}
+	}
+	return index
+}
+
+func bookmarkParagraph(bookmarkStart *etree.Element) *etree.Element {
+	for e := bookmarkStart.Parent(); e != nil; e = e.Parent() {
+		if isWordElement(e, "p") {
+			return e
+		}
+	}
+	for sibling := bookmarkStart.NextSibling(); sibling != nil; sibling = sibling.NextSibling() {
+		if isWordElement(sibling, "p") {
+			return sibling
+		}
+		if paragraph := findElement(sibling, ".//w:p"); paragraph != nil {
+			return paragraph
+		}
+	}
+	return nil
+}
+
+func (fe *FieldEngine) relativePosition(bookmark string) string {
+	target, ok := fe.references.bookmarks[bookmark]
+	if !ok || fe.currentParagraph == nil {
+		return ""
+	}
+	if fe.references.paragraphOrder[target] > fe.references.paragraphOrder[fe.currentParagraph] {
+		return positionBelow
+	}
+	return positionAbove
+}
+
+func (fe *FieldEngine) evaluateReference(field FieldInstruction) (fieldResult, bool) {
+	if len(field.Arguments) == 0 || fe.ParagraphNumbers == nil {
+		return fieldResult{}, false
+	}
+	bookmark := field.Arguments[0]
+	result := fieldResult{}
+	if field.HasSwitch(`\h`) {
+		result.Hyperlink = bookmark
+	}
+
+	withNumber := field.HasSwitch(`\n`) || field.HasSwitch(`\r`) || field.HasSwitch(`\w`)
+	if withNumber {
+		paragraph, ok := fe.references.bookmarks[bookmark]
+		if !ok {
+			return fieldResult{}, false
+		}
+		number, ok := fe.ParagraphNumbers[paragraph]
+		if !ok {
+			return fieldResult{}, false
+		}
+		switch {
+		case field.HasSwitch(`\w`):
+			result.Text = number.FullContext()
+		case field.HasSwitch(`\r`):
+			result.Text = number.RelativeContext(fe.currentNumbers[number.NumID])
+		default:
+			result.Text = number.NoContext()
+		}
+	}
+
+	if field.HasSwitch(`\p`) {
+		position := fe.relativePosition(bookmark)
+		if position == "" {
+			return fieldResult{}, false
+		}
+		result.Text = strings.TrimSpace(result.Text + " " + position)
+	} else if !withNumber {
+		return fieldResult{}, false
+	}
+	return result, true
+}
+
+func (fe *FieldEngine) evaluateNoteReference(field FieldInstruction) (fieldResult, bool) {
+	if len(field.Arguments) == 0 {
+		return fieldResult{}, false
+	}
+	bookmark := field.Arguments[0]
+	note, ok := fe.references.notes[bookmark]
+	if !ok {
+		return fieldResult{}, false
+	}
+
+	format := fe.references.footnoteFormat
+	if note.Endnote {
+		format = fe.references.endnoteFormat
+	}
+	result := fieldResult{
+		Text:        formatNumber(note.Index, format),
+		Superscript: field.HasSwitch(`\f`),
+	}
+	if field.HasSwitch(`\h`) {
+		result.Hyperlink = bookmark
+	}
+	if field.HasSwitch(`\p`) {
+		if position := fe.relativePosition(bookmark); position != "" {
+			result.Text += " " + position
+		}
+	}
+	return result, true
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+const (
+	relationshipsNS      = "http://schemas.openxmlformats.org/package/2006/relationships"
+	relTypeBase          = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/"
+	strictRelTypeBase    = "http://purl.oclc.org/ooxml/officeDocument/relationships/"
+	relTypeAltChunk      = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/aFChunk"
+	relTypeGlossary      = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/glossaryDocument"
+	relTypeNumbering     = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/numbering"
+	targetModeExternal   = "External"
+	relationshipIDPrefix = "rId"
+)
+
+type Relationship struct {
+	ID         string
+	Type       string
+	Target     string
+	TargetMode string
+}
+
+type Relationships struct {
+	doc *etree.Document
+}
+
+func NewRelationships() *Relationships {
+	doc := etree.NewDocument()
+	doc.CreateProcInst("xml", `version="1.0" encoding="UTF-8" standalone="yes"`)
+	root := doc.CreateElement("Relationships")
+	root.CreateAttr("xmlns", relationshipsNS)
+	return &Relationships{doc: doc}
+}
+
+func ParseRelationships(content []byte) (*Relationships, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(content); err != nil {
+		return nil, err
+	}
+	if doc.Root() == nil {
+		return nil, fmt.Errorf("пустой файл связей")
+	}
+	return &Relationships{doc: doc}, nil
+}
+
+func (r *Relationships) All() []Relationship {
+	var result []Relationship
+	for _, element := range r.doc.Root().SelectElements("Relationship") {
+		result = append(result, Relationship{
+			ID:         element.SelectAttrValue("Id", ""),
+			Type:       element.SelectAttrValue("Type", ""),
+			Target:     element.SelectAttrValue("Target", ""),
+			TargetMode: element.SelectAttrValue("TargetMode", ""),
+		})
+	}
+	return result
+}
+
+func (r *Relationships) ByID(id string) (Relationship, bool) {
+	for _, rel := range r.All() {
+		if rel.ID == id {
+			return rel, true
+		}
+	}
+	return Relationship{}, false
+}
+
+func (r *Relationships) ByType(relType string) []Relationship {
+	var result []Relationship
+	for _, rel := range r.All() {
+		if isRelationshipType(rel.Type, relType) {
+			result = append(result, rel)
+		}
+	}
+	return result
+}
+
+func (r *Relationships) Add(relType, target, targetMode string) string {
+	used := make(map[string]bool)
+	for _, rel := range r.All() {
+		used[rel.ID] = true
+	}
+	id := ""
+	for i := len(used) + 1; ; i++ {
+		id = fmt.Sprintf("%s%d", relationshipIDPrefix, i)
+		if !used[id] {
+			break
+		}
+	}
+
+	element := r.doc.Root().CreateElement("Relationship")
+	element.CreateAttr("Id", id)
+	element.CreateAttr("Type", relType)
+	element.CreateAttr("Target", target)
+	if targetMode != "" {
+		element.CreateAttr("TargetMode", targetMode)
+	}
+	return id
+}
+
+func (r *Relationships) Remove(id string) {
+	root := r.doc.Root()
+	for _, element := range root.SelectElements("Relationship") {
+		if element.SelectAttrValue("Id", "") == id {
+			root.RemoveChild(element)
+		}
+	}
+}
+
+func (r *Relationships) Bytes() ([]byte, error) {
+	return r.doc.WriteToBytes()
+}
+
+func readPartRelationships(pkg *Package, partName string) (*Relationships, error) {
+	content, err := pkg.ReadPart(relationshipsPartName(partName))
+	if os.IsNotExist(err) {
+		return NewRelationships(), nil
+	} else if err != nil {
+		return nil, err
+	}
+	return ParseRelationships(content)
+}
+
+func writePartRelationships(pkg *Package, partName string, rels *Relationships) error {
+	content, err := rels.Bytes()
+	if err != nil {
+		return err
+	}
+	pkg.WritePart(relationshipsPartName(partName), content)
+	return nil
+}
+
+func isRelationshipType(actual, relType string) bool {
+	if actual == relType {
+		return true
+	}
+	return strings.HasPrefix(relType, relTypeBase) && actual == strictRelTypeBase+strings.TrimPrefix(relType, relTypeBase)
+}
+
+func relationshipsPartName(partName string) string {
+	dir, file := path.Split(partName)
+	return path.Join(dir, "_rels", file+".rels")
+}
+
+func resolveRelationshipTarget(sourcePartName, target string) string {
+	resolved := path.Join(path.Dir(sourcePartName), target)
+	if strings.HasPrefix(target, "/") {
+		resolved = path.Clean(target)
+	}
+	resolved = strings.TrimPrefix(resolved, "/")
+	if resolved == "" || resolved == "." || resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return ""
+	}
+	return resolved
+}
+
+func relativeRelationshipTarget(sourcePartName, partName string) string {
+	dir := path.Dir(sourcePartName)
+	if dir == "." {
+		return partName
+	}
+	if strings.HasPrefix(partName, dir+"/") {
+		return strings.TrimPrefix(partName, dir+"/")
+	}
+	return "/" + partName
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/beevik/etree"
+)
+
+const (
+	DefaultRevisionAuthor = "DocxNumConvert"
+	revisionDateLayout    = "2006-01-02T15:04:05Z"
+)
+
+type revisionRecorder struct {
+	author string
+	date   string
+	nextID int
+}
+
+func (dnp *DocxNumberingProcessor) newRevisionRecorder(root *etree.Element) *revisionRecorder {
+	if !dnp.RecordRevisions {
+		return nil
+	}
+
+	maxID := 0
+	for _, element := range findAllElements(root, "//w:*") {
+		if id, ok := getAttribute(element, "id"); ok {
+			if n, err := strconv.Atoi(id); err == nil && n > maxID {
+				maxID = n
+			}
+		}
+	}
+	return dnp.revisionRecorderAfter(maxID)
+}
+
+func (dnp *DocxNumberingProcessor) revisionRecorderAfter(maxID int) *revisionRecorder {
+	recorder := &revisionRecorder{
+		author: dnp.RevisionAuthor,
+		nextID: maxID + 1,
+	}
+	if recorder.author == "" {
+		recorder.author = DefaultRevisionAuthor
+	}
+	if !dnp.RevisionDate.IsZero() {
+		recorder.date = dnp.RevisionDate.UTC().Format(revisionDateLayout)
+	} else if dnp.UseCurrentTime {
+		recorder.date = time.Now().UTC().Format(revisionDateLayout)
+	}
+	return recorder
+}
+
+func (rr *revisionRecorder) newRevision(context *etree.Element, tagNameLocal string) *etree.Element {
+	revision := createElement(context, tagNameLocal, nil)
+	rr.stampRevision(context, revision)
+	return revision
+}
+
+func (rr *revisionRecorder) stampRevision(context, revision *etree.Element) {
+	attrPrefix := wordAttributePrefix(context)
+	revision.CreateAttr(attrPrefix+":id", strconv.Itoa(rr.nextID))
+	revision.CreateAttr(attrPrefix+":author", rr.author)
+	if rr.date != "" {
+		revision.CreateAttr(attrPrefix+":date", rr.date)
+	} else if date := findAttr(revision, wordProcessingMLPrefix, "date"); date != nil {
+		revision.RemoveAttr(date.FullKey())
+	}
+	rr.nextID++
+}
+
+func (rr *revisionRecorder) wrapInsertion(context, content *etree.Element) *etree.Element {
+	ins := rr.newRevision(context, "ins")
+	ins.AddChild(content)
+	return ins
+}
+
+func (rr *revisionRecorder) recordPropertiesChange(pPr *etree.Element) {
+	if change := findElement(pPr, "./w:pPrChange"); change != nil {
+		oldPPr := findElement(change, "./w:pPr")
+		numPr := findElement(pPr, "./w:numPr")
+		if oldPPr != nil && numPr != nil && findElement(oldPPr, "./w:numPr") == nil {
+			insertParagraphProperty(oldPPr, numPr.Copy())
+		}
+		return
+	}
+
+	oldPPr := createElement(pPr, "pPr", nil)
+	for _, child := range pPr.ChildElements() {
+		if isWordElement(child, "rPr") || isWordElement(child, "sectPr") {
+			continue
+		}
+		oldPPr.AddChild(child.Copy())
+	}
+
+	change := rr.newRevision(pPr, "pPrChange")
+	change.AddChild(oldPPr)
+	pPr.AddChild(change)
+}
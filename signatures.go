@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	relTypeSignatureOrigin = "http://schemas.openxmlformats.org/package/2006/relationships/digital-signature/origin"
+	signaturePartsDir      = "_xmlsignatures/"
+	signatureOriginExt     = "sigs"
+)
+
+const (
+	SignaturePolicyRefuse = "refuse"
+	SignaturePolicyStrip  = "strip"
+	SignaturePolicyWarn   = "warn"
+)
+
+var ErrSignedPackage = errors.New("пакет содержит цифровую подпись")
+
+func (dnp *DocxNumberingProcessor) applySignaturePolicy(pkg *Package) error {
+	signatureParts, err := findSignatureParts(pkg)
+	if err != nil {
+		return fmt.Errorf("ошибка поиска цифровых подписей: %w", err)
+	}
+	if len(signatureParts) == 0 {
+		return nil
+	}
+
+	switch dnp.SignaturePolicy {
+	case SignaturePolicyRefuse:
+		return fmt.Errorf("%w, обработка отменена (режим %s)", ErrSignedPackage, SignaturePolicyRefuse)
+	case SignaturePolicyStrip:
+		if err := removeSignatureParts(pkg, signatureParts); err != nil {
+			return fmt.Errorf("ошибка удаления цифровой подписи: %w", err)
+		}
+		dnp.addWarning("пакет был подписан, цифровая подпись удалена (режим %s, удалено частей: %d)", SignaturePolicyStrip, len(signatureParts))
+	default:
+		dnp.addWarning("пакет подписан, после обработки цифровая подпись станет недействительной (режим %s)", SignaturePolicyWarn)
+	}
+	return nil
+}
+
+func findSignatureParts(pkg *Package) ([]string, error) {
+	seen := make(map[string]bool)
+
+	packageRels, err := readPartRelationships(pkg, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, rel := range packageRels.ByType(relTypeSignatureOrigin) {
+		if rel.TargetMode == targetModeExternal {
+			continue
+		}
+		originPartName := resolveRelationshipTarget("", rel.Target)
+		if originPartName == "" {
+			continue
+		}
+		seen[originPartName] = true
+		originRels, err := readPartRelationships(pkg, originPartName)
+		if err != nil {
+			return nil, err
+		}
+		for _, signatureRel := range originRels.All() {
+			if partName := resolveRelationshipTarget(originPartName, signatureRel.Target); signatureRel.TargetMode != targetModeExternal && partName != "" {
+				seen[partName] = true
+			}
+		}
+	}
+	for _, partName := range pkg.PartNames() {
+		if strings.HasPrefix(partName, signaturePartsDir) {
+			seen[partName] = true
+		}
+	}
+
+	var signatureParts []string
+	for partName := range seen {
+		if pkg.HasPart(partName) {
+			signatureParts = append(signatureParts, partName)
+		}
+	}
+	sort.Strings(signatureParts)
+	return signatureParts, nil
+}
+
+func removeSignatureParts(pkg *Package, signatureParts []string) error {
+	packageRels, err := readPartRelationships(pkg, "")
+	if err != nil {
+		return err
+	}
+	for _, rel := range packageRels.ByType(relTypeSignatureOrigin) {
+		packageRels.Remove(rel.ID)
+	}
+	if err := writePartRelationships(pkg, "", packageRels); err != nil {
+		return err
+	}
+
+	contentTypes, err := readContentTypes(pkg)
+	if err != nil {
+		return err
+	}
+	for _, partName := range signatureParts {
+		pkg.RemovePart(partName)
+		contentTypes.RemoveOverride(partName)
+	}
+	contentTypes.RemoveDefault(signatureOriginExt)
+	return writeContentTypes(pkg, contentTypes)
+}
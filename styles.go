@@ -0,0 +1,314 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+const maxHeadingLevel = 9
+
+type StyleDefinition struct {
+	ID           string
+	Name         string
+	Type         string
+	BasedOn      string
+	OutlineLevel int
+	NumID        string
+	Ilvl         string
+	Bidi         string
+}
+
+type StyleSheet struct {
+	Styles                map[string]*StyleDefinition
+	DefaultParagraphStyle string
+	DefaultBidi           string
+}
+
+func NewStyleSheet() *StyleSheet {
+	return &StyleSheet{
+		Styles: make(map[string]*StyleDefinition),
+	}
+}
+
+func (ss *StyleSheet) ParseStylesXML(stylesXMLContent []byte) error {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(stylesXMLContent); err != nil {
+		return err
+	}
+
+	if bidiElement := findElement(doc.Root(), "./w:docDefaults/w:pPrDefault/w:pPr/w:bidi"); bidiElement != nil {
+		ss.DefaultBidi = onOffAttributeValue(bidiElement)
+	}
+	for _, style := range findAllElements(doc.Root(), "//w:style") {
+		styleID, ok := getAttribute(style, "styleId")
+		if !ok || styleID == "" {
+			continue
+		}
+		definition := &StyleDefinition{ID: styleID, OutlineLevel: -1}
+		definition.Type, _ = getAttribute(style, "type")
+		if nameElement := findElement(style, "./w:name"); nameElement != nil {
+			definition.Name, _ = getAttribute(nameElement, "val")
+		}
+		if basedOnElement := findElement(style, "./w:basedOn"); basedOnElement != nil {
+			definition.BasedOn, _ = getAttribute(basedOnElement, "val")
+		}
+		if outlineLvlElement := findElement(style, "./w:pPr/w:outlineLvl"); outlineLvlElement != nil {
+			if val, okVal := getAttribute(outlineLvlElement, "val"); okVal {
+				if level, err := strconv.Atoi(val); err == nil {
+					definition.OutlineLevel = level
+				}
+			}
+		}
+		if numPrElement := findElement(style, "./w:pPr/w:numPr"); numPrElement != nil {
+			definition.NumID, definition.Ilvl = numberingPropertyValues(numPrElement)
+		}
+		if bidiElement := findElement(style, "./w:pPr/w:bidi"); bidiElement != nil {
+			definition.Bidi = onOffAttributeValue(bidiElement)
+		}
+		if definition.Type == "paragraph" && ss.DefaultParagraphStyle == "" {
+			if isDefault, ok := getAttribute(style, "default"); ok && isOnOffValue(isDefault) {
+				ss.DefaultParagraphStyle = styleID
+			}
+		}
+		ss.Styles[styleID] = definition
+	}
+	return nil
+}
+
+func (ss *StyleSheet) StyleNumbering(styleID string) (numID, ilvl string) {
+	visited := make(map[string]bool)
+	for styleID != "" && !visited[styleID] {
+		visited[styleID] = true
+		definition, ok := ss.Styles[styleID]
+		if !ok {
+			return "", ""
+		}
+		if definition.NumID != "" {
+			return definition.NumID, definition.Ilvl
+		}
+		styleID = definition.BasedOn
+	}
+	return "", ""
+}
+
+func (ss *StyleSheet) StyleBidi(styleID string) bool {
+	if ss == nil {
+		return false
+	}
+	if styleID == "" {
+		styleID = ss.DefaultParagraphStyle
+	}
+	visited := make(map[string]bool)
+	for styleID != "" && !visited[styleID] {
+		visited[styleID] = true
+		definition, ok := ss.Styles[styleID]
+		if !ok {
+			break
+		}
+		if definition.Bidi != "" {
+			return isOnOffValue(definition.Bidi)
+		}
+		styleID = definition.BasedOn
+	}
+	return ss.DefaultBidi != "" && isOnOffValue(ss.DefaultBidi)
+}
+
+func (ss *StyleSheet) HeadingLevel(styleID string) int {
+	visited := make(map[string]bool)
+	for styleID != "" && !visited[styleID] {
+		visited[styleID] = true
+		definition, ok := ss.Styles[styleID]
+		if !ok {
+			return builtInHeadingLevel(styleID)
+		}
+		if definition.OutlineLevel >= 0 {
+			return outlineLevelToHeadingLevel(definition.OutlineLevel)
+		}
+		if level := builtInHeadingLevel(definition.Name); level > 0 {
+			return level
+		}
+		styleID = definition.BasedOn
+	}
+	return 0
+}
+
+func (ss *StyleSheet) ParagraphHeadingLevel(paragraph *etree.Element) int {
+	if outlineLvlElement := findElement(paragraph, "./w:pPr/w:outlineLvl"); outlineLvlElement != nil {
+		if val, ok := getAttribute(outlineLvlElement, "val"); ok {
+			if level, err := strconv.Atoi(val); err == nil {
+				return outlineLevelToHeadingLevel(level)
+			}
+		}
+	}
+	if pStyleElement := findElement(paragraph, "./w:pPr/w:pStyle"); pStyleElement != nil {
+		if styleID, ok := getAttribute(pStyleElement, "val"); ok {
+			return ss.HeadingLevel(styleID)
+		}
+	}
+	return 0
+}
+
+func (ss *StyleSheet) TocLevel(styleID string) int {
+	if definition, ok := ss.Styles[styleID]; ok {
+		if level := builtInStyleLevel(definition.Name, "toc"); level > 0 {
+			return level
+		}
+	}
+	return builtInStyleLevel(styleID, "toc")
+}
+
+func (ss *StyleSheet) TocStyleID(level int) string {
+	styleIDs := make([]string, 0, len(ss.Styles))
+	for styleID := range ss.Styles {
+		styleIDs = append(styleIDs, styleID)
+	}
+	sort.Strings(styleIDs)
+	for _, styleID := range styleIDs {
+		definition := ss.Styles[styleID]
+		if definition.Type == "paragraph" && builtInStyleLevel(definition.Name, "toc") == level {
+			return definition.ID
+		}
+	}
+	return "TOC" + strconv.Itoa(level)
+}
+
+func outlineLevelToHeadingLevel(outlineLevel int) int {
+	if outlineLevel < 0 || outlineLevel >= maxHeadingLevel {
+		return 0
+	}
+	return outlineLevel + 1
+}
+
+func builtInHeadingLevel(name string) int {
+	return builtInStyleLevel(name, "heading")
+}
+
+func builtInStyleLevel(name, prefix string) int {
+	name = strings.ToLower(strings.ReplaceAll(name, " ", ""))
+	if !strings.HasPrefix(name, prefix) {
+		return 0
+	}
+	level, err := strconv.Atoi(strings.TrimPrefix(name, prefix))
+	if err != nil || level < 1 || level > maxHeadingLevel {
+		return 0
+	}
+	return level
+}
+
+func ensureCharacterStyle(pkg *Package, stylesPartName, styleID string) error {
+	if stylesPartName == "" || styleID == "" {
+		return nil
+	}
+	content, err := pkg.ReadPart(stylesPartName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(content); err != nil {
+		return err
+	}
+	stylesRoot := doc.Root()
+	for _, existing := range findAllElements(stylesRoot, "./w:style") {
+		if id, _ := getAttribute(existing, "styleId"); id == styleID {
+			return nil
+		}
+	}
+
+	style := createElement(stylesRoot, "style", nil)
+	setAttribute(style, "type", "character")
+	setAttribute(style, "customStyle", "1")
+	setAttribute(style, "styleId", styleID)
+
+	name := createElement(stylesRoot, "name", nil)
+	setAttribute(name, "val", styleID)
+	style.AddChild(name)
+	style.AddChild(createElement(stylesRoot, "qFormat", nil))
+
+	stylesRoot.AddChild(style)
+
+	output, err := doc.WriteToBytes()
+	if err != nil {
+		return err
+	}
+	pkg.WritePart(stylesPartName, output)
+	return nil
+}
+
+func rewriteNumberedStyles(pkg *Package, stylesPartName string, numberingDefinitions map[string]*NumberingDefinition, styleSheet *StyleSheet) error {
+	if stylesPartName == "" {
+		return nil
+	}
+	content, err := pkg.ReadPart(stylesPartName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(content); err != nil {
+		return err
+	}
+
+	paragraphFormatter := NewParagraphFormatter(numberingDefinitions)
+	modified := false
+	for _, style := range findAllElements(doc.Root(), "./w:style") {
+		styleID, _ := getAttribute(style, "styleId")
+		numPrs := findAllElements(style, "./w:pPr/w:numPr")
+		numID, ilvl := styleSheet.StyleNumbering(styleID)
+		linkedIlvl := paragraphFormatter.styleLevel(numID, styleID)
+		if len(numPrs) == 0 && linkedIlvl == "" {
+			continue
+		}
+		if ilvl == "" {
+			ilvl = linkedIlvl
+		}
+		if ilvl == "" {
+			ilvl = "0"
+		}
+		if level := paragraphFormatter.NumberingLevel(ilvl, numID); level != nil && level.ParagraphProperties != nil {
+			ensureStyleParagraphProperties(style)
+			applyLevelParagraphProperties(style, level)
+		}
+
+		for _, numPr := range numPrs {
+			numPr.Parent().RemoveChild(numPr)
+		}
+		modified = true
+	}
+	if !modified {
+		return nil
+	}
+
+	output, err := doc.WriteToBytes()
+	if err != nil {
+		return err
+	}
+	pkg.WritePart(stylesPartName, output)
+	return nil
+}
+
+func ensureStyleParagraphProperties(style *etree.Element) {
+	if findElement(style, "./w:pPr") != nil {
+		return
+	}
+	pPr := createElement(style, "pPr", nil)
+	for _, child := range style.ChildElements() {
+		for _, tag := range []string{"rPr", "tblPr", "trPr", "tcPr", "tblStylePr"} {
+			if isWordElement(child, tag) {
+				style.InsertChildAt(child.Index(), pPr)
+				return
+			}
+		}
+	}
+	style.AddChild(pPr)
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+const tocBookmarkPrefix = "_Toc"
+
+type tocField struct {
+	instruction strings.Builder
+	begin       *etree.Element
+	separate    *etree.Element
+	end         *etree.Element
+}
+
+type tocHeading struct {
+	paragraph *etree.Element
+	level     int
+	bookmark  string
+}
+
+type tableOfContents struct {
+	styleSheet      *StyleSheet
+	keepPageNumbers bool
+	bookmarks       *bookmarkAllocator
+	nextTocNumber   int
+}
+
+func regenerateTablesOfContents(root *etree.Element, styleSheet *StyleSheet, keepPageNumbers bool) {
+	toc := &tableOfContents{
+		styleSheet:      styleSheet,
+		keepPageNumbers: keepPageNumbers,
+		bookmarks:       newBookmarkAllocator(root),
+	}
+	for name := range toc.bookmarks.names {
+		if n, err := strconv.Atoi(strings.TrimPrefix(name, tocBookmarkPrefix)); err == nil && n >= toc.nextTocNumber {
+			toc.nextTocNumber = n + 1
+		}
+	}
+
+	for _, field := range findTocFields(root) {
+		toc.regenerate(root, field)
+	}
+}
+
+func findTocFields(root *etree.Element) []*tocField {
+	var open, fields []*tocField
+	for _, run := range findAllElements(root, "//w:r") {
+		for _, child := range run.ChildElements() {
+			n := len(open)
+			switch {
+			case isWordElement(child, "fldChar"):
+				fldCharType, _ := getAttribute(child, "fldCharType")
+				switch {
+				case fldCharType == fieldCharBegin:
+					open = append(open, &tocField{begin: run})
+				case fldCharType == fieldCharSeparate && n > 0:
+					open[n-1].separate = run
+				case fldCharType == fieldCharEnd && n > 0:
+					field := open[n-1]
+					open = open[:n-1]
+					field.end = run
+					if ParseFieldInstruction(field.instruction.String()).Name == "TOC" {
+						fields = append(fields, field)
+					}
+				}
+			case isWordElement(child, "instrText") && n > 0 && open[n-1].separate == nil:
+				open[n-1].instruction.WriteString(child.Text())
+			}
+		}
+	}
+	return fields
+}
+
+func (toc *tableOfContents) regenerate(root *etree.Element, field *tocField) {
+	instruction := ParseFieldInstruction(field.instruction.String())
+	if instruction.HasSwitch(`\c`) || instruction.HasSwitch(`\f`) || instruction.HasSwitch(`\t`) {
+		return
+	}
+	minLevel, maxLevel := 1, maxHeadingLevel
+	if levels, ok := instruction.Switches[`\o`]; ok {
+		minLevel, maxLevel = parseTocLevels(levels)
+	}
+
+	first := field.begin.Parent()
+	last := field.end.Parent()
+	if first == nil || last == nil || !isWordElement(first, "p") || !isWordElement(last, "p") || first.Parent() != last.Parent() {
+		return
+	}
+	if (field.separate != nil && field.separate.Parent() != first) || first.Index() > last.Index() {
+		return
+	}
+	container := first.Parent()
+	oldParagraphs := []*etree.Element{first}
+	for sibling := first.NextSibling(); sibling != nil && first != last; sibling = sibling.NextSibling() {
+		oldParagraphs = append(oldParagraphs, sibling)
+		if sibling == last {
+			break
+		}
+	}
+
+	inToc := make(map[*etree.Element]bool)
+	for _, paragraph := range oldParagraphs {
+		inToc[paragraph] = true
+	}
+	var headings []tocHeading
+	for _, paragraph := range findAllElements(root, "//w:p") {
+		if inToc[paragraph] || isInsideDeletion(paragraph) {
+			continue
+		}
+		level := toc.styleSheet.ParagraphHeadingLevel(paragraph)
+		if level < minLevel || level > maxLevel {
+			continue
+		}
+		headings = append(headings, tocHeading{paragraph: paragraph, level: level})
+	}
+
+	pageNumbers := cachedPageNumbers(oldParagraphs)
+	entryProperties := cachedEntryProperties(oldParagraphs, toc.styleSheet)
+	hyperlinks := instruction.HasSwitch(`\h`)
+	noPagesFrom, noPagesTo := 0, -1
+	if levels, ok := instruction.Switches[`\n`]; ok {
+		noPagesFrom, noPagesTo = 1, maxHeadingLevel
+		if levels != "" {
+			noPagesFrom, noPagesTo = parseTocLevels(levels)
+		}
+	}
+
+	var entries []*etree.Element
+	for _, heading := range headings {
+		if hyperlinks || toc.keepPageNumbers {
+			heading.bookmark = toc.ensureBookmark(heading.paragraph)
+		}
+		pages := pageNumbers
+		if heading.level >= noPagesFrom && heading.level <= noPagesTo {
+			pages = nil
+		}
+		entries = append(entries, toc.buildEntry(container, heading, entryProperties, pages, hyperlinks))
+	}
+
+	leading := createElement(container, "p", nil)
+	if pPr := findElement(first, "./w:pPr"); pPr != nil {
+		leading.AddChild(pPr.Copy())
+	}
+	var codeRuns []*etree.Element
+	codeStarted, hasLeading := false, false
+	for _, child := range first.ChildElements() {
+		if child == field.end {
+			break
+		}
+		if isWordElement(child, "pPr") {
+			continue
+		}
+		if child == field.begin {
+			codeStarted = true
+		}
+		if !codeStarted {
+			leading.AddChild(child)
+			hasLeading = true
+			continue
+		}
+		codeRuns = append(codeRuns, child)
+		if child == field.separate {
+			break
+		}
+	}
+	if field.separate == nil {
+		separate := createElement(container, "r", nil)
+		fldChar := createElement(container, "fldChar", nil)
+		setAttribute(fldChar, "fldCharType", fieldCharSeparate)
+		separate.AddChild(fldChar)
+		codeRuns = append(codeRuns, separate)
+	}
+
+	opening := createElement(container, "p", nil)
+	if len(entries) > 0 {
+		opening = entries[0]
+	} else {
+		if pPr := findElement(first, "./w:pPr"); pPr != nil {
+			opening.AddChild(pPr.Copy())
+		}
+		entries = append(entries, opening)
+	}
+
+	closing := createElement(container, "p", nil)
+	if pPr := findElement(last, "./w:pPr"); pPr != nil {
+		closing.AddChild(pPr.Copy())
+	}
+	closingStarted := false
+	for _, child := range last.ChildElements() {
+		if child == field.end {
+			closingStarted = true
+		}
+		if closingStarted {
+			closing.AddChild(child)
+		}
+	}
+	entries = append(entries, closing)
+	if hasLeading {
+		entries = append([]*etree.Element{leading}, entries...)
+	}
+
+	position := first.Index()
+	for _, paragraph := range oldParagraphs {
+		container.RemoveChild(paragraph)
+	}
+	for i, paragraph := range entries {
+		container.InsertChildAt(position+i, paragraph)
+	}
+
+	index := 0
+	if pPr := findElement(opening, "./w:pPr"); pPr != nil {
+		index = pPr.Index() + 1
+	}
+	for _, run := range codeRuns {
+		opening.InsertChildAt(index, run)
+		index++
+	}
+}
+
+func parseTocLevels(levels string) (int, int) {
+	parts := strings.SplitN(levels, "-", 2)
+	minLevel, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || minLevel < 1 {
+		minLevel = 1
+	}
+	maxLevel := minLevel
+	if len(parts) == 2 {
+		if n, err := strconv.Atoi(strings.TrimSpace(parts[1])); err == nil {
+			maxLevel = n
+		}
+	}
+	if maxLevel > maxHeadingLevel {
+		maxLevel = maxHeadingLevel
+	}
+	return minLevel, maxLevel
+}
+
+func isInsideDeletion(element *etree.Element) bool {
+	for e := element.Parent(); e != nil; e = e.Parent() {
+		if isWordElement(e, "del") || isWordElement(e, "moveFrom") {
+			return true
+		}
+	}
+	return false
+}
+
+func cachedPageNumbers(paragraphs []*etree.Element) map[string]string {
+	pageNumbers := make(map[string]string)
+	type pageRef struct {
+		instruction strings.Builder
+		separated   bool
+		result      strings.Builder
+	}
+	var open []*pageRef
+
+	for _, paragraph := range paragraphs {
+		for _, run := range findAllElements(paragraph, ".//w:r") {
+			for _, child := range run.ChildElements() {
+				n := len(open)
+				switch {
+				case isWordElement(child, "fldChar"):
+					fldCharType, _ := getAttribute(child, "fldCharType")
+					switch {
+					case fldCharType == fieldCharBegin:
+						open = append(open, &pageRef{})
+					case fldCharType == fieldCharSeparate && n > 0:
+						open[n-1].separated = true
+					case fldCharType == fieldCharEnd && n > 0:
+						ref := open[n-1]
+						open = open[:n-1]
+						instruction := ParseFieldInstruction(ref.instruction.String())
+						if instruction.Name == "PAGEREF" && len(instruction.Arguments) > 0 {
+							pageNumbers[instruction.Arguments[0]] = strings.TrimSpace(ref.result.String())
+						}
+					}
+				case isWordElement(child, "instrText") && n > 0 && !open[n-1].separated:
+					open[n-1].instruction.WriteString(child.Text())
+				case isWordElement(child, "t") && n > 0 && open[n-1].separated:
+					open[n-1].result.WriteString(child.Text())
+				}
+			}
+		}
+	}
+	return pageNumbers
+}
+
+func cachedEntryProperties(paragraphs []*etree.Element, styleSheet *StyleSheet) map[int]*etree.Element {
+	properties := make(map[int]*etree.Element)
+	for _, paragraph := range paragraphs {
+		pStyle := findElement(paragraph, "./w:pPr/w:pStyle")
+		if pStyle == nil {
+			continue
+		}
+		styleID, _ := getAttribute(pStyle, "val")
+		if level := styleSheet.TocLevel(styleID); level > 0 && properties[level] == nil {
+			properties[level] = findElement(paragraph, "./w:pPr").Copy()
+		}
+	}
+	return properties
+}
+
+func (toc *tableOfContents) ensureBookmark(paragraph *etree.Element) string {
+	for _, bookmarkStart := range findAllElements(paragraph, ".//w:bookmarkStart") {
+		if name, ok := getAttribute(bookmarkStart, "name"); ok && strings.HasPrefix(name, tocBookmarkPrefix) {
+			return name
+		}
+	}
+
+	name := fmt.Sprintf("%s%09d", tocBookmarkPrefix, toc.nextTocNumber)
+	for toc.bookmarks.names[name] {
+		toc.nextTocNumber++
+		name = fmt.Sprintf("%s%09d", tocBookmarkPrefix, toc.nextTocNumber)
+	}
+	toc.nextTocNumber++
+	toc.bookmarks.names[name] = true
+	toc.bookmarks.wrapParagraph(paragraph, name)
+	return name
+}
+
+func (toc *tableOfContents) buildEntry(context *etree.Element, heading tocHeading, entryProperties map[int]*etree.Element, pageNumbers map[string]string, hyperlinks bool) *etree.Element {
+	entry := createElement(context, "p", nil)
+	if pPr := entryProperties[heading.level]; pPr != nil {
+		entry.AddChild(pPr.Copy())
+	} else {
+		pPr := createElement(context, "pPr", nil)
+		pStyle := createElement(context, "pStyle", nil)
+		setAttribute(pStyle, "val", toc.styleSheet.TocStyleID(heading.level))
+		pPr.AddChild(pStyle)
+		entry.AddChild(pPr)
+	}
+
+	content := entry
+	if hyperlinks && heading.bookmark != "" {
+		content = createElement(context, "hyperlink", nil)
+		setAttribute(content, "anchor", heading.bookmark)
+		setAttribute(content, "history", "1")
+		entry.AddChild(content)
+	}
+
+	run := createElement(context, "r", nil)
+	for i, segment := range strings.Split(paragraphPlainText(heading.paragraph), "\t") {
+		if i > 0 {
+			run.AddChild(createElement(context, "tab", nil))
+		}
+		if segment != "" {
+			t := createElement(context, "t", nil)
+			t.CreateAttr("xml:space", "preserve")
+			t.SetText(segment)
+			run.AddChild(t)
+		}
+	}
+	content.AddChild(run)
+
+	if page, ok := pageNumbers[heading.bookmark]; ok && toc.keepPageNumbers && page != "" {
+		tabRun := createElement(context, "r", nil)
+		tabRun.AddChild(createElement(context, "tab", nil))
+		content.AddChild(tabRun)
+		for _, pageRun := range buildComplexField(context, fmt.Sprintf(" PAGEREF %s \\h ", heading.bookmark), page) {
+			content.AddChild(pageRun)
+		}
+	}
+	return entry
+}
+
+func buildComplexField(context *etree.Element, instruction, result string) []*etree.Element {
+	fieldCharRun := func(fldCharType string) *etree.Element {
+		run := createElement(context, "r", nil)
+		fldChar := createElement(context, "fldChar", nil)
+		setAttribute(fldChar, "fldCharType", fldCharType)
+		run.AddChild(fldChar)
+		return run
+	}
+
+	instrRun := createElement(context, "r", nil)
+	instrText := createElement(context, "instrText", nil)
+	instrText.CreateAttr("xml:space", "preserve")
+	instrText.SetText(instruction)
+	instrRun.AddChild(instrText)
+
+	resultRun := createElement(context, "r", nil)
+	t := createElement(context, "t", nil)
+	t.SetText(result)
+	resultRun.AddChild(t)
+
+	return []*etree.Element{fieldCharRun(fieldCharBegin), instrRun, fieldCharRun(fieldCharSeparate), resultRun, fieldCharRun(fieldCharEnd)}
+}
+
+func paragraphPlainText(paragraph *etree.Element) string {
+	var text strings.Builder
+	var collect func(element *etree.Element)
+	collect = func(element *etree.Element) {
+		for _, child := range element.ChildElements() {
+			switch {
+			case isWordElement(child, "pPr"), isWordElement(child, "rPr"), isWordElement(child, "del"), isWordElement(child, "moveFrom"):
+			case isWordElement(child, "t"):
+				text.WriteString(child.Text())
+			case isWordElement(child, "tab"):
+				text.WriteString("\t")
+			default:
+				collect(child)
+			}
+		}
+	}
+	collect(paragraph)
+	return text.String()
+}
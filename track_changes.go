@@ -0,0 +1,218 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+const (
+	TrackChangesAll    = "all"
+	TrackChangesAccept = "accept"
+	TrackChangesReject = "reject"
+)
+
+var moveRangeTags = []string{"moveFromRangeStart", "moveFromRangeEnd", "moveToRangeStart", "moveToRangeEnd"}
+
+var keptOnPropertiesRestore = map[string]map[string]bool{
+	"pPr":    {"rPr": true, "sectPr": true},
+	"rPr":    {"ins": true, "del": true, "moveFrom": true, "moveTo": true},
+	"sectPr": {"headerReference": true, "footerReference": true, "footnotePr": true, "endnotePr": true},
+	"trPr":   {"ins": true, "del": true},
+	"tcPr":   {"cellIns": true, "cellDel": true, "cellMerge": true},
+}
+
+var keptBeforeRestoredProperties = map[string]bool{"rPr": true, "sectPr": true}
+
+func ApplyTrackChanges(documentRoot *etree.Element, mode string) {
+	switch mode {
+	case TrackChangesAccept:
+		acceptAllRevisions(documentRoot)
+	case TrackChangesReject:
+		rejectAllRevisions(documentRoot)
+	default:
+		CleanDocument(documentRoot)
+	}
+}
+
+func acceptAllRevisions(documentRoot *etree.Element) {
+	resolveRevisions(documentRoot, []string{"del", "moveFrom"}, []string{"ins", "moveTo"})
+
+	for _, change := range findPropertyChanges(documentRoot) {
+		removeElement(change)
+	}
+}
+
+func rejectAllRevisions(documentRoot *etree.Element) {
+	resolveRevisions(documentRoot, []string{"ins", "moveTo"}, []string{"del", "moveFrom"})
+
+	for _, delText := range findAllElements(documentRoot, "//w:delText") {
+		delText.Tag = "t"
+	}
+	for _, delInstrText := range findAllElements(documentRoot, "//w:delInstrText") {
+		delInstrText.Tag = "instrText"
+	}
+
+	for _, change := range findPropertyChanges(documentRoot) {
+		restoreOldProperties(change)
+	}
+}
+
+func resolveRevisions(documentRoot *etree.Element, removedTags, keptTags []string) {
+	for _, row := range findAllElements(documentRoot, "//w:tr") {
+		for _, tag := range removedTags {
+			if findElement(row, "./w:trPr/w:"+tag) != nil {
+				removeElement(row)
+				break
+			}
+		}
+	}
+	for _, tag := range removedTags {
+		for _, revision := range findAllElements(documentRoot, "//w:"+tag) {
+			if !isRevisionMarker(revision) {
+				removeElement(revision)
+			}
+		}
+	}
+	for _, tag := range keptTags {
+		for _, revision := range findAllElements(documentRoot, "//w:"+tag) {
+			if !isRevisionMarker(revision) {
+				unwrapElement(revision)
+			}
+		}
+	}
+
+	for _, paragraph := range findAllElements(documentRoot, "//w:p") {
+		for _, tag := range removedTags {
+			if findElement(paragraph, "./w:pPr/w:rPr/w:"+tag) != nil {
+				mergeParagraphWithNext(paragraph)
+				break
+			}
+		}
+	}
+	removeRevisionMarkers(documentRoot, append(removedTags, keptTags...)...)
+
+	for _, tag := range moveRangeTags {
+		for _, rangeMarker := range findAllElements(documentRoot, "//w:"+tag) {
+			removeElement(rangeMarker)
+		}
+	}
+}
+
+func findPropertyChanges(documentRoot *etree.Element) []*etree.Element {
+	var changes []*etree.Element
+	for _, element := range findAllElements(documentRoot, "//w:*") {
+		if strings.HasSuffix(element.Tag, "PrChange") || strings.HasSuffix(element.Tag, "PrExChange") || element.Tag == "tblGridChange" {
+			changes = append(changes, element)
+		}
+	}
+	return changes
+}
+
+func restoreOldProperties(change *etree.Element) {
+	properties := change.Parent()
+	if properties == nil {
+		return
+	}
+
+	var oldProperties *etree.Element
+	for _, child := range change.ChildElements() {
+		if child.Tag == properties.Tag {
+			oldProperties = child
+			break
+		}
+	}
+	removeElement(change)
+	if oldProperties == nil {
+		return
+	}
+
+	restored := make(map[string]bool)
+	for _, child := range oldProperties.ChildElements() {
+		restored[child.Tag] = true
+	}
+	kept := keptOnPropertiesRestore[properties.Tag]
+	for _, child := range properties.ChildElements() {
+		if !kept[child.Tag] || restored[child.Tag] {
+			properties.RemoveChild(child)
+		}
+	}
+	index := 0
+	if keptBeforeRestoredProperties[properties.Tag] {
+		index = len(properties.Child)
+	}
+	for _, child := range oldProperties.ChildElements() {
+		properties.InsertChildAt(index, child)
+		index++
+	}
+}
+
+func isRevisionMarker(element *etree.Element) bool {
+	parent := element.Parent()
+	return parent != nil && (isWordElement(parent, "rPr") || isWordElement(parent, "trPr") || isWordElement(parent, "tcPr"))
+}
+
+func removeRevisionMarkers(documentRoot *etree.Element, tags ...string) {
+	for _, tag := range tags {
+		for _, marker := range findAllElements(documentRoot, "//w:"+tag) {
+			if isRevisionMarker(marker) {
+				removeElement(marker)
+			}
+		}
+	}
+}
+
+func isParagraphMarkDeleted(paragraph *etree.Element) bool {
+	return findElement(paragraph, "./w:pPr/w:rPr/w:del") != nil || findElement(paragraph, "./w:pPr/w:rPr/w:moveFrom") != nil
+}
+
+func mergeParagraphWithNext(paragraph *etree.Element) {
+	next := paragraph.NextSibling()
+	for next != nil && isRangeMarker(next) {
+		next = next.NextSibling()
+	}
+	if next == nil || !isWordElement(next, "p") {
+		return
+	}
+
+	index := 0
+	if nextPPr := findElement(next, "./w:pPr"); nextPPr != nil {
+		index = nextPPr.Index() + 1
+	}
+	for _, child := range paragraph.ChildElements() {
+		if isWordElement(child, "pPr") {
+			continue
+		}
+		next.InsertChildAt(index, child)
+		index++
+	}
+	removeElement(paragraph)
+}
+
+func isRangeMarker(element *etree.Element) bool {
+	switch element.Tag {
+	case "bookmarkStart", "bookmarkEnd", "commentRangeStart", "commentRangeEnd", "permStart", "permEnd", "proofErr",
+		"moveFromRangeStart", "moveFromRangeEnd", "moveToRangeStart", "moveToRangeEnd":
+		return isWordElement(element, element.Tag)
+	}
+	return false
+}
+
+func unwrapElement(element *etree.Element) {
+	parent := element.Parent()
+	if parent == nil {
+		return
+	}
+	index := element.Index()
+	children := append([]etree.Token(nil), element.Child...)
+	for i, child := range children {
+		parent.InsertChildAt(index+i, child)
+	}
+	parent.RemoveChild(element)
+}
+
+func removeElement(element *etree.Element) {
+	if parent := element.Parent(); parent != nil {
+		parent.RemoveChild(element)
+	}
+}
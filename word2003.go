@@ -0,0 +1,110 @@
+package main
+
+import (
+	"github.com/beevik/etree"
+)
+
+const word2003NS = "http://schemas.microsoft.com/office/word/2003/wordml"
+
+var word2003NumberFormats = map[string]string{
+	"0":   "decimal",
+	"1":   "upperRoman",
+	"2":   "lowerRoman",
+	"3":   "upperLetter",
+	"4":   "lowerLetter",
+	"5":   "ordinal",
+	"6":   "cardinalText",
+	"7":   "ordinalText",
+	"22":  "decimalZero",
+	"23":  "bullet",
+	"255": "none",
+}
+
+func (np *NumberingParser) ParseWord2003Lists(listsElement *etree.Element) {
+	for _, listDef := range findAllElements(listsElement, "./w:listDef") {
+		listDefID, ok := getAttribute(listDef, "listDefId")
+		if !ok || listDefID == "" {
+			continue
+		}
+		np.AbstractNumberingData[listDefID] = make(map[string]AbstractLvlData)
+
+		for _, lvl := range findAllElements(listDef, "./w:lvl") {
+			ilvl, okLvl := getAttribute(lvl, "ilvl")
+			if !okLvl || ilvl == "" {
+				continue
+			}
+			lvlData := parseLevelData(lvl)
+			lvlData.Format = "decimal"
+			if nfcElement := findElement(lvl, "./w:nfc"); nfcElement != nil {
+				if val, okVal := getAttribute(nfcElement, "val"); okVal {
+					if format, known := word2003NumberFormats[val]; known {
+						lvlData.Format = format
+					}
+				}
+			}
+			np.AbstractNumberingData[listDefID][ilvl] = lvlData
+		}
+	}
+
+	for _, list := range findAllElements(listsElement, "./w:list") {
+		ilfo, ok := getAttribute(list, "ilfo")
+		if !ok || ilfo == "" {
+			continue
+		}
+		listDefID := ""
+		if ilstElement := findElement(list, "./w:ilst"); ilstElement != nil {
+			listDefID, _ = getAttribute(ilstElement, "val")
+		}
+		if numDef := np.buildNumberingDefinition(listDefID, list); numDef != nil {
+			np.NumberingDefinitions[ilfo] = numDef
+		}
+	}
+}
+
+func (dnp *DocxNumberingProcessor) processWord2003Document(documentContent []byte) ([]byte, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(documentContent); err != nil {
+		return nil, err
+	}
+	documentRoot := doc.Root()
+	snapshot := takeXMLSnapshot(doc)
+
+	if listsElement := findElement(documentRoot, "./w:lists"); listsElement != nil {
+		dnp.NumberingParser.ParseWord2003Lists(listsElement)
+	}
+	if dnp.FlattenFields {
+		NewFieldEngine(nil, nil).FlattenFields(documentRoot)
+	}
+	paragraphFormatter := NewParagraphFormatter(dnp.NumberingParser.NumberingDefinitions)
+
+	for _, paragraph := range findAllElements(documentRoot, "//w:body//w:p") {
+		listPr := findElement(paragraph, "./w:pPr/w:listPr")
+		if listPr == nil {
+			continue
+		}
+
+		ilvl := "0"
+		if ilvlElement := findElement(listPr, "./w:ilvl"); ilvlElement != nil {
+			if val, ok := getAttribute(ilvlElement, "val"); ok {
+				ilvl = val
+			}
+		}
+		ilfo := ""
+		if ilfoElement := findElement(listPr, "./w:ilfo"); ilfoElement != nil {
+			ilfo, _ = getAttribute(ilfoElement, "val")
+		}
+
+		level := paragraphFormatter.NumberingLevel(ilvl, ilfo)
+		var numberRun *etree.Element
+		if numPrefix := paragraphFormatter.FormatNumbering(ilvl, ilfo, ilfo != ""); numPrefix != "" {
+			numberRun = dnp.addNumberingToParagraph(paragraph, numPrefix, level, paragraphFormatter.StyleSheet, nil)
+		}
+		applyLevelParagraphProperties(paragraph, level)
+		listPr.Parent().RemoveChild(listPr)
+		if numberRun != nil {
+			alignNumberRun(paragraph, numberRun, level)
+		}
+	}
+
+	return snapshot.serialize(doc, documentContent)
+}
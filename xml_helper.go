@@ -1,66 +1,367 @@
 package main
 
 import (
+	"strings"
+
 	"github.com/beevik/etree"
 )
 
 const (
-	wordNS                 = "http://schemas.openxmlformats.org/wordprocessingml/2006/main"
-	wordProcessingMLPrefix = "w"
+	wordNS                      = "http://schemas.openxmlformats.org/wordprocessingml/2006/main"
+	strictWordNS                = "http://purl.oclc.org/ooxml/wordprocessingml/main"
+	officeRelationshipsNS       = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+	strictOfficeRelationshipsNS = "http://purl.oclc.org/ooxml/officeDocument/relationships"
+	wordProcessingMLPrefix      = "w"
+	relationshipsPrefix         = "r"
 )
 
+var namespacesByPrefix = map[string][]string{
+	wordProcessingMLPrefix: {wordNS, strictWordNS, word2003NS},
+	relationshipsPrefix:    {officeRelationshipsNS, strictOfficeRelationshipsNS},
+}
+
+type pathStep struct {
+	descendant bool
+	prefix     string
+	local      string
+	attrPrefix string
+	attrLocal  string
+	attrValue  *string
+}
+
+func compilePath(xpath string) (absolute bool, steps []pathStep) {
+	descendant := false
+	switch {
+	case strings.HasPrefix(xpath, "//"):
+		absolute, descendant = true, true
+		xpath = xpath[2:]
+	case strings.HasPrefix(xpath, ".//"):
+		descendant = true
+		xpath = xpath[3:]
+	case strings.HasPrefix(xpath, "./"):
+		xpath = xpath[2:]
+	case strings.HasPrefix(xpath, "/"):
+		absolute = true
+		xpath = xpath[1:]
+	}
+
+	for _, segment := range strings.Split(xpath, "/") {
+		if segment == "" {
+			descendant = true
+			continue
+		}
+		step := pathStep{descendant: descendant}
+		descendant = false
+
+		if open := strings.Index(segment, "[@"); open >= 0 && strings.HasSuffix(segment, "]") {
+			predicate := segment[open+2 : len(segment)-1]
+			segment = segment[:open]
+			if eq := strings.Index(predicate, "="); eq >= 0 {
+				value := strings.Trim(predicate[eq+1:], `'"`)
+				step.attrValue = &value
+				predicate = predicate[:eq]
+			}
+			step.attrPrefix, step.attrLocal = splitQualifiedName(predicate)
+		}
+		step.prefix, step.local = splitQualifiedName(segment)
+		steps = append(steps, step)
+	}
+	return absolute, steps
+}
+
+func splitQualifiedName(name string) (prefix, local string) {
+	if i := strings.Index(name, ":"); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
+
+func (step pathStep) matches(element *etree.Element) bool {
+	if !matchesName(element, step.prefix, step.local) {
+		return false
+	}
+	if step.attrLocal == "" {
+		return true
+	}
+	attr := findAttr(element, step.attrPrefix, step.attrLocal)
+	return attr != nil && (step.attrValue == nil || attr.Value == *step.attrValue)
+}
+
+func matchesName(element *etree.Element, prefix, local string) bool {
+	if element == nil || (local != "*" && element.Tag != local) {
+		return false
+	}
+	uris, known := namespacesByPrefix[prefix]
+	if !known {
+		return element.Space == prefix
+	}
+	uri := element.NamespaceURI()
+	for _, candidate := range uris {
+		if uri == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+func isWordElement(element *etree.Element, tagNameLocal string) bool {
+	return matchesName(element, wordProcessingMLPrefix, tagNameLocal)
+}
+
+func isNamespaceInPrefix(uri, prefix string) bool {
+	for _, candidate := range namespacesByPrefix[prefix] {
+		if uri == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+func lookupNamespaceURI(element *etree.Element, prefix string) string {
+	for e := element; e != nil; e = e.Parent() {
+		for _, attr := range e.Attr {
+			if prefix == "" && attr.Space == "" && attr.Key == "xmlns" {
+				return attr.Value
+			}
+			if prefix != "" && attr.Space == "xmlns" && attr.Key == prefix {
+				return attr.Value
+			}
+		}
+	}
+	return ""
+}
+
+func collectDescendants(element *etree.Element, step pathStep, result *[]*etree.Element) {
+	for _, child := range element.ChildElements() {
+		if step.matches(child) {
+			*result = append(*result, child)
+		}
+		collectDescendants(child, step, result)
+	}
+}
+
 func findElement(parent *etree.Element, xpath string) *etree.Element {
-	return parent.FindElement(xpath)
+	if elements := findAllElements(parent, xpath); len(elements) > 0 {
+		return elements[0]
+	}
+	return nil
 }
 
 func findAllElements(parent *etree.Element, xpath string) []*etree.Element {
-	return parent.FindElements(xpath)
+	if parent == nil {
+		return nil
+	}
+	absolute, steps := compilePath(xpath)
+
+	current := []*etree.Element{parent}
+	if absolute {
+		root := parent
+		for root.Parent() != nil {
+			root = root.Parent()
+		}
+		if len(steps) == 0 {
+			return []*etree.Element{root}
+		}
+
+		first := steps[0]
+		steps = steps[1:]
+		current = nil
+		if first.matches(root) {
+			current = append(current, root)
+		}
+		if first.descendant {
+			collectDescendants(root, first, &current)
+		}
+	}
+
+	for _, step := range steps {
+		var next []*etree.Element
+		seen := make(map[*etree.Element]bool)
+		for _, element := range current {
+			var matched []*etree.Element
+			if step.descendant {
+				collectDescendants(element, step, &matched)
+			} else {
+				for _, child := range element.ChildElements() {
+					if step.matches(child) {
+						matched = append(matched, child)
+					}
+				}
+			}
+			for _, m := range matched {
+				if !seen[m] {
+					seen[m] = true
+					next = append(next, m)
+				}
+			}
+		}
+		current = next
+	}
+	return current
+}
+
+func findAttr(element *etree.Element, prefix, attrNameLocal string) *etree.Attr {
+	for i, attr := range element.Attr {
+		if attr.Key != attrNameLocal || attr.Space == "" || attr.Space == "xmlns" {
+			continue
+		}
+		if _, known := namespacesByPrefix[prefix]; !known {
+			if attr.Space == prefix {
+				return &element.Attr[i]
+			}
+			continue
+		}
+		if isNamespaceInPrefix(lookupNamespaceURI(element, attr.Space), prefix) {
+			return &element.Attr[i]
+		}
+	}
+	return nil
 }
 
 func getAttribute(element *etree.Element, attrNameLocal string) (string, bool) {
 	if element == nil {
 		return "", false
 	}
-	attr := element.SelectAttr(wordProcessingMLPrefix + ":" + attrNameLocal)
-	if attr == nil {
-		attr = element.SelectAttr(attrNameLocal)
-		if attr == nil {
-			return "", false
+	if attr := findAttr(element, wordProcessingMLPrefix, attrNameLocal); attr != nil {
+		return attr.Value, true
+	}
+	for _, attr := range element.Attr {
+		if attr.Space == "" && attr.Key == attrNameLocal {
+			return attr.Value, true
 		}
 	}
-	return attr.Value, true
+	return "", false
 }
 
-func parseNumberingInfo(numPrElement *etree.Element) (ilvl string, numID string, found bool) {
-	if numPrElement == nil {
-		return "", "", false
+func getRelationshipAttribute(element *etree.Element, attrNameLocal string) (string, bool) {
+	if element == nil {
+		return "", false
 	}
+	if attr := findAttr(element, relationshipsPrefix, attrNameLocal); attr != nil {
+		return attr.Value, true
+	}
+	return "", false
+}
 
-	ilvlElement := findElement(numPrElement, ".//w:ilvl")
-	numIDElement := findElement(numPrElement, ".//w:numId")
+func setAttribute(element *etree.Element, attrNameLocal, value string) {
+	if attr := findAttr(element, wordProcessingMLPrefix, attrNameLocal); attr != nil {
+		attr.Value = value
+		return
+	}
+	element.CreateAttr(wordAttributePrefix(element)+":"+attrNameLocal, value)
+}
 
-	var ilvlVal, numIDVal string
-	var ilvlFound, numIDFound bool
+func wordAttributePrefix(element *etree.Element) string {
+	for e := element; e != nil; e = e.Parent() {
+		for _, attr := range e.Attr {
+			if attr.Space == "xmlns" && isNamespaceInPrefix(attr.Value, wordProcessingMLPrefix) {
+				return attr.Key
+			}
+		}
+	}
+	return wordProcessingMLPrefix
+}
 
-	if ilvlElement != nil {
-		ilvlVal, ilvlFound = getAttribute(ilvlElement, "val")
+func wordNamespaceURI(element *etree.Element) string {
+	if uri := lookupNamespaceURI(element, wordAttributePrefix(element)); uri != "" {
+		return uri
 	}
-	if numIDElement != nil {
-		numIDVal, numIDFound = getAttribute(numIDElement, "val")
+	if uri := lookupNamespaceURI(element, ""); isNamespaceInPrefix(uri, wordProcessingMLPrefix) {
+		return uri
 	}
+	return wordNS
+}
 
-	if ilvlFound && numIDFound {
-		return ilvlVal, numIDVal, true
+func numberingPropertyValues(numPrElement *etree.Element) (numID, ilvl string) {
+	if numIDElement := findElement(numPrElement, "./w:numId"); numIDElement != nil {
+		numID, _ = getAttribute(numIDElement, "val")
+	}
+	if ilvlElement := findElement(numPrElement, "./w:ilvl"); ilvlElement != nil {
+		ilvl, _ = getAttribute(ilvlElement, "val")
 	}
-	return "", "", false
+	return numID, ilvl
 }
 
-func createElement(tagNameLocal string, attributes map[string]string) *etree.Element {
-	element := etree.NewElement(wordProcessingMLPrefix + ":" + tagNameLocal)
-	if attributes != nil {
-		for attrNameLocal, attrValue := range attributes {
-			element.CreateAttr(wordProcessingMLPrefix+":"+attrNameLocal, attrValue)
-		}
+func createElement(context *etree.Element, tagNameLocal string, attributes map[string]string) *etree.Element {
+	attrPrefix := wordAttributePrefix(context)
+	elementPrefix := attrPrefix
+	if isWordElement(context, context.Tag) {
+		elementPrefix = context.Space
+	}
+
+	tag := tagNameLocal
+	if elementPrefix != "" {
+		tag = elementPrefix + ":" + tagNameLocal
+	}
+	element := etree.NewElement(tag)
+	if lookupNamespaceURI(context, attrPrefix) == "" {
+		element.CreateAttr("xmlns:"+attrPrefix, wordNamespaceURI(context))
+	}
+
+	for attrNameLocal, attrValue := range attributes {
+		element.CreateAttr(attrPrefix+":"+attrNameLocal, attrValue)
 	}
 	return element
 }
+
+func normalizeWordElement(element *etree.Element) *etree.Element {
+	normalized := normalizeWordTree(element)
+	if normalized != nil {
+		normalized.CreateAttr("xmlns:"+wordProcessingMLPrefix, wordNS)
+	}
+	return normalized
+}
+
+func normalizeWordTree(element *etree.Element) *etree.Element {
+	if element == nil || !isWordElement(element, element.Tag) {
+		return nil
+	}
+
+	normalized := etree.NewElement(wordProcessingMLPrefix + ":" + element.Tag)
+	for _, attr := range element.Attr {
+		switch {
+		case attr.Space == "" && attr.Key == "xmlns", attr.Space == "xmlns":
+			continue
+		case attr.Space == "" || isNamespaceInPrefix(lookupNamespaceURI(element, attr.Space), wordProcessingMLPrefix):
+			normalized.CreateAttr(wordProcessingMLPrefix+":"+attr.Key, attr.Value)
+		}
+	}
+	for _, child := range element.ChildElements() {
+		if normalizedChild := normalizeWordTree(child); normalizedChild != nil {
+			normalized.AddChild(normalizedChild)
+		}
+	}
+	if text := element.Text(); strings.TrimSpace(text) != "" {
+		normalized.SetText(text)
+	}
+	return normalized
+}
+
+func adoptWordElement(context, normalized *etree.Element) *etree.Element {
+	attrPrefix := wordAttributePrefix(context)
+	elementPrefix := attrPrefix
+	if isWordElement(context, context.Tag) {
+		elementPrefix = context.Space
+	}
+
+	adopted := normalized.Copy()
+	adopted.RemoveAttr("xmlns:" + wordProcessingMLPrefix)
+	var rename func(element *etree.Element)
+	rename = func(element *etree.Element) {
+		element.Space = elementPrefix
+		for i := range element.Attr {
+			if element.Attr[i].Space == wordProcessingMLPrefix {
+				element.Attr[i].Space = attrPrefix
+			}
+		}
+		for _, child := range element.ChildElements() {
+			rename(child)
+		}
+	}
+	rename(adopted)
+
+	if lookupNamespaceURI(context, attrPrefix) == "" {
+		adopted.CreateAttr("xmlns:"+attrPrefix, wordNamespaceURI(context))
+	}
+	return adopted
+}
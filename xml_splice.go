@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"github.com/beevik/etree"
+)
+
+type xmlSnapshot struct {
+	skeleton     []byte
+	paragraphs   []*etree.Element
+	digests      [][]byte
+	replacements map[*etree.Element]*xmlReplacement
+	replaced     []*xmlReplacement
+	elements     int
+	unspliceable bool
+}
+
+type xmlReplacement struct {
+	original *etree.Element
+	elements []*etree.Element
+	index    int
+}
+
+type byteRange struct {
+	start, end int64
+}
+
+func takeXMLSnapshot(doc *etree.Document) *xmlSnapshot {
+	return newXMLSnapshot(doc, nil)
+}
+
+func newXMLSnapshot(doc *etree.Document, replacements map[*etree.Element]*xmlReplacement) *xmlSnapshot {
+	snapshot := &xmlSnapshot{replacements: replacements}
+	skeleton := sha256.New()
+	writer := bufio.NewWriter(skeleton)
+	snapshot.writeSkeleton(writer, doc.Root(), &doc.WriteSettings)
+	writer.Flush()
+	snapshot.skeleton = skeleton.Sum(nil)
+
+	for _, paragraph := range snapshot.paragraphs {
+		snapshot.digests = append(snapshot.digests, elementDigest(paragraph, &doc.WriteSettings))
+	}
+	return snapshot
+}
+
+func (s *xmlSnapshot) writeSkeleton(w *bufio.Writer, element *etree.Element, settings *etree.WriteSettings) {
+	s.elements++
+	w.WriteString("<" + element.FullTag())
+	for _, attr := range element.Attr {
+		fmt.Fprintf(w, " %s=%q", attr.FullKey(), attr.Value)
+	}
+	w.WriteByte('>')
+	for i := 0; i < len(element.Child); i++ {
+		token := element.Child[i]
+		child, ok := token.(*etree.Element)
+		switch {
+		case ok && s.replacements[child] != nil:
+			replacement := s.replacements[child]
+			if i+len(replacement.elements) > len(element.Child) {
+				s.unspliceable = true
+				return
+			}
+			for j, replacementElement := range replacement.elements {
+				if element.Child[i+j] != replacementElement {
+					s.unspliceable = true
+				}
+			}
+			s.replaced = append(s.replaced, &xmlReplacement{original: replacement.original, elements: replacement.elements, index: s.elements + 1})
+			s.writeSkeleton(w, replacement.original, settings)
+			i += len(replacement.elements) - 1
+		case ok && isWordElement(child, "p"):
+			s.paragraphs = append(s.paragraphs, child)
+			w.WriteByte(0)
+		case ok:
+			s.writeSkeleton(w, child, settings)
+		default:
+			token.WriteTo(w, settings)
+		}
+	}
+	w.WriteString("</>")
+}
+
+func elementDigest(element *etree.Element, settings *etree.WriteSettings) []byte {
+	digest := sha256.New()
+	writeElement(digest, element, settings)
+	return digest.Sum(nil)
+}
+
+func writeElement(w io.Writer, element *etree.Element, settings *etree.WriteSettings) {
+	writer := bufio.NewWriter(w)
+	element.WriteTo(writer, settings)
+	writer.Flush()
+}
+
+func (s *xmlSnapshot) replaceElement(original *etree.Element, elements []*etree.Element) {
+	parent := original.Parent()
+	for _, element := range elements {
+		parent.InsertChild(original, element)
+	}
+	parent.RemoveChild(original)
+	if len(elements) == 0 {
+		s.unspliceable = true
+		return
+	}
+	if s.replacements == nil {
+		s.replacements = make(map[*etree.Element]*xmlReplacement)
+	}
+	s.replacements[elements[0]] = &xmlReplacement{original: original, elements: elements}
+}
+
+func (s *xmlSnapshot) serialize(doc *etree.Document, original []byte) ([]byte, error) {
+	if output, ok := s.splice(doc, original); ok {
+		return output, nil
+	}
+	return doc.WriteToBytes()
+}
+
+func (s *xmlSnapshot) splice(doc *etree.Document, original []byte) ([]byte, bool) {
+	if s.unspliceable {
+		return nil, false
+	}
+	current := newXMLSnapshot(doc, s.replacements)
+	if current.unspliceable || len(current.replaced) != len(s.replacements) {
+		return nil, false
+	}
+	if !bytes.Equal(current.skeleton, s.skeleton) || len(current.paragraphs) != len(s.paragraphs) {
+		return nil, false
+	}
+	for i, paragraph := range current.paragraphs {
+		if paragraph != s.paragraphs[i] {
+			return nil, false
+		}
+	}
+
+	indexes := make([]int, len(current.replaced))
+	for i, replacement := range current.replaced {
+		indexes[i] = replacement.index
+	}
+	ranges, replacedRanges, err := spliceRanges(original, indexes)
+	if err != nil || len(ranges) != len(s.paragraphs) || len(replacedRanges) != len(current.replaced) {
+		return nil, false
+	}
+
+	var output bytes.Buffer
+	output.Grow(len(original))
+	var offset int64
+	next := 0
+	writeReplacedBefore := func(position int64) {
+		for ; next < len(replacedRanges) && replacedRanges[next].start < position; next++ {
+			output.Write(original[offset:replacedRanges[next].start])
+			for _, element := range current.replaced[next].elements {
+				writeElement(&output, element, &doc.WriteSettings)
+			}
+			offset = replacedRanges[next].end
+		}
+	}
+	for i, paragraph := range current.paragraphs {
+		if bytes.Equal(current.digests[i], s.digests[i]) {
+			continue
+		}
+		writeReplacedBefore(ranges[i].start)
+		output.Write(original[offset:ranges[i].start])
+		writeElement(&output, paragraph, &doc.WriteSettings)
+		offset = ranges[i].end
+	}
+	writeReplacedBefore(int64(len(original)) + 1)
+	output.Write(original[offset:])
+	return output.Bytes(), true
+}
+
+func spliceRanges(content []byte, replacedIndexes []int) (paragraphs, replaced []byteRange, err error) {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	var start int64
+	depth, paragraphDepth, replacedDepth, elements := 0, 0, 0, 0
+	for {
+		offset := decoder.InputOffset()
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return paragraphs, replaced, nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if paragraphDepth != 0 {
+				continue
+			}
+			if t.Name.Local == "p" && isNamespaceInPrefix(t.Name.Space, wordProcessingMLPrefix) {
+				if replacedDepth != 0 {
+					return nil, nil, fmt.Errorf("абзац внутри заменяемого элемента")
+				}
+				paragraphDepth = depth
+				start = offset
+				continue
+			}
+			elements++
+			if len(replaced) < len(replacedIndexes) && replacedIndexes[len(replaced)] == elements {
+				replacedDepth = depth
+				start = offset
+			}
+		case xml.EndElement:
+			if depth == paragraphDepth {
+				paragraphs = append(paragraphs, byteRange{start: start, end: decoder.InputOffset()})
+				paragraphDepth = 0
+			} else if depth == replacedDepth {
+				replaced = append(replaced, byteRange{start: start, end: decoder.InputOffset()})
+				replacedDepth = 0
+			}
+			depth--
+		}
+	}
+}